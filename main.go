@@ -86,13 +86,32 @@ func doMain() (err error) {
 			sprintcore.AutoupdateService(),
 			service.UserService(),
 			service.SecurityLogService(),
+			service.FileLogSink(),
+			service.SyslogLogSink(),
+			service.WebhookLogSink(),
+			service.AuditLogService(),
 			service.PageService(),
+			service.PageSyncService(),
+			service.FragmentService(),
+			service.PageScheduler(),
+			service.HtmlSanitizer(),
+			service.RenderService(),
+			service.MarkdownRenderer(),
+			service.HtmlRenderer(),
+			service.PlainTextRenderer(),
+			service.JsonBlocksRenderer(),
+			service.MediaService(),
+			service.TrafficService(),
 		)),
 		app.Server(sprintserver.ServerScanner(
 			sprintserver.AuthorizationMiddleware(),
 			sprintserver.GrpcServerFactory("control-grpc-server"),
 			sprintserver.ControlServer(),
 			server.UIGrpcServer(),
+			server.MediaPage(),
+			server.SitemapPage(),
+			server.RobotsPage(),
+			server.FeedPage(),
 			sprintserver.HttpServerFactory("control-gateway-server"),
 			sprintserver.TlsConfigFactory("tls-config"),
 		)),
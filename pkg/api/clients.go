@@ -17,6 +17,7 @@ package api
 
 import (
 	"github.com/codeallergy/glue"
+	"github.com/codeallergy/template/pkg/pb"
 	"reflect"
 )
 
@@ -28,4 +29,8 @@ type AdminClient interface {
 
 	AdminCommand(command string, args []string) (string, error)
 
+	ExportPages() ([]*pb.PageFile, error)
+
+	ImportPages(files []*pb.PageFile, dryRun bool) ([]*pb.PageChange, error)
+
 }
@@ -18,8 +18,11 @@ import (
 	"context"
 	"github.com/codeallergy/store"
 	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprint"
 	"github.com/codeallergy/template/pkg/pb"
+	"google.golang.org/protobuf/proto"
 	"reflect"
+	"time"
 )
 
 
@@ -41,6 +44,7 @@ type UserService interface {
 
 	GetUserIdByEmail(ctx context.Context, email string) (string, error)
 
+	// ErrVersionConflict if user.Version is not the stored one, the stored version is incremented
 	SaveUser(ctx context.Context, user *pb.UserEntity) error
 
 	RemoveUser(ctx context.Context, userId string) error
@@ -56,16 +60,49 @@ type UserService interface {
 	SaveRecoverCode(ctx context.Context, email string, rc *pb.RecoverCodeEntity, ttlSeconds int) error
 
 	ValidateRecoverCode(ctx context.Context, email string, code string) error
+
+	// returns new session epoch, tokens with the previous one are not valid anymore
+	RevokeSessions(ctx context.Context, userId string) (int64, error)
 }
 
 var SecurityLogServiceClass = reflect.TypeOf((*SecurityLogService)(nil)).Elem()
 
 type SecurityLogService interface {
 
-	LogEvent(ctx context.Context, userId, eventName, remoteIP, userAgent string) error
+	// event time and name are filled by the service if empty
+	LogEvent(ctx context.Context, userId string, event *pb.SecurityLogEntity) error
 
 	EnumEvents(ctx context.Context, userId string, cb func(item *pb.SecurityLogEntity) bool) error
 
+	// returns number of verified events, ErrBrokenLogChain on the first broken link
+	VerifyEvents(ctx context.Context, userId string) (int, error)
+
+}
+
+var SecurityLogSinkClass = reflect.TypeOf((*SecurityLogSink)(nil)).Elem()
+
+// additional output of security events, sink does nothing if not configured
+type SecurityLogSink interface {
+	glue.NamedBean
+
+	// called after the event is stored, should not block for a long time
+	Emit(userId string, event *pb.SecurityLogEntity) error
+
+}
+
+var AuditLogServiceClass = reflect.TypeOf((*AuditLogService)(nil)).Elem()
+
+type AuditLogService interface {
+
+	// before and after are optional snapshots of the target, used to calculate changes
+	LogAction(ctx context.Context, event *pb.AuditLogEntity, before, after proto.Message) error
+
+	// fromTime and toTime are unix seconds, zero means no bound
+	EnumActions(ctx context.Context, fromTime, toTime int64, cb func(item *pb.AuditLogEntity) bool) error
+
+	// returns number of verified actions, ErrBrokenLogChain on the first broken link
+	VerifyActions(ctx context.Context) (int, error)
+
 }
 
 var PageServiceClass = reflect.TypeOf((*PageService)(nil)).Elem()
@@ -75,12 +112,227 @@ type PageService interface {
 	// ErrPageNotFound on error
 	GetPage(ctx context.Context, name string) (*pb.PageEntity, error)
 
-	CreatePage(ctx context.Context, page *pb.AdminPage) error
+	// every change is stored as a new revision made by authorId
+	CreatePage(ctx context.Context, page *pb.AdminPage, authorId string) error
 
-	UpdatePage(ctx context.Context, page *pb.AdminPage) error
+	// ErrVersionConflict if page.Version is not the current one
+	UpdatePage(ctx context.Context, page *pb.AdminPage, authorId string) error
 
+	// revisions are kept after removal, so the page can be restored, translations are removed as well
 	RemovePage(ctx context.Context, name string) error
 
+	// pages in the default locale, translations are not enumerated
 	EnumPages(ctx context.Context, cb func(page *pb.PageEntity) bool) error
 
+	// translation of the page, the default page for the empty or default locale, ErrPageNotFound on error
+	GetPageLocale(ctx context.Context, name, locale string) (*pb.PageEntity, error)
+
+	// locales of the page translations in sorted order, the default locale is not included
+	PageLocales(ctx context.Context, name string) ([]string, error)
+
+	// removes one translation, translations have no revision history
+	RemovePageLocale(ctx context.Context, name, locale string) error
+
+	// requested locales followed by their fallbacks, the chain stops at the default locale
+	LocaleChain(requested []string) []string
+
+	DefaultLocale() string
+
+	// enumerates revisions from the oldest to the newest
+	EnumPageRevisions(ctx context.Context, name string, cb func(revision *pb.PageRevisionEntity) bool) error
+
+	// ErrPageRevisionNotFound on error
+	GetPageRevision(ctx context.Context, name string, revision int64) (*pb.PageRevisionEntity, error)
+
+	// writes the content of the revision as the new revision
+	RestorePageRevision(ctx context.Context, name string, revision int64, authorId string) error
+
+	// publishes scheduled pages and archives expired ones, returns number of changed pages
+	PublishScheduled(ctx context.Context, now int64) (int, error)
+
+	// moves the page with nested pages and leaves redirects from the old names
+	MovePage(ctx context.Context, name, newName, authorId string) error
+
+	// sets the order of the given children of the parent, empty parent for top level pages
+	ReorderPages(ctx context.Context, parent string, names []string) error
+
+	// follows the redirect chain, returns nil if there is no redirect
+	ResolveRedirect(ctx context.Context, name string) (*pb.PageRedirectEntity, error)
+
+	// rejects redirects from existing pages and redirects making a loop
+	SaveRedirect(ctx context.Context, redirect *pb.PageRedirectEntity) error
+
+	RemoveRedirect(ctx context.Context, from string) error
+
+	EnumRedirects(ctx context.Context, cb func(redirect *pb.PageRedirectEntity) bool) error
+
+	// navigation tree of public pages visible to the viewer, nil for guests
+	Menu(ctx context.Context, now int64, viewer *sprint.AuthorizedUser) ([]*pb.MenuItem, error)
+
+	// ranked public pages visible to the viewer matching words of the query, returns total number of found pages
+	SearchPages(ctx context.Context, query string, offset, limit int, now int64, viewer *sprint.AuthorizedUser) (int, []*pb.SearchResult, error)
+
+	// indexes all pages from scratch, returns number of indexed pages
+	RebuildIndex(ctx context.Context) (int, error)
+
+}
+
+var PageSchedulerClass = reflect.TypeOf((*PageScheduler)(nil)).Elem()
+
+// runs PublishScheduled in background
+type PageScheduler interface {
+	glue.InitializingBean
+	glue.DisposableBean
+
+}
+
+var HtmlSanitizerClass = reflect.TypeOf((*HtmlSanitizer)(nil)).Elem()
+
+// allow-list sanitizer of the rendered page content, each content type has own policy
+type HtmlSanitizer interface {
+	glue.InitializingBean
+
+	// returns safe html and removed elements and attributes like '<script>' or 'a.onclick'
+	Sanitize(contentType pb.ContentType, html string) (string, []string)
+
+}
+
+var RenderServiceClass = reflect.TypeOf((*RenderService)(nil)).Elem()
+
+// renders page content to the sanitized html by the renderer of the content type
+type RenderService interface {
+	glue.InitializingBean
+
+	// result is cached by page name, locale and version
+	RenderPage(page *pb.PageEntity) (*RenderedPage, error)
+
+	// result is cached by fragment name and version, includes are not resolved
+	RenderFragment(fragment *pb.FragmentEntity) (*RenderedPage, error)
+
+	// replaces {{.Name}} variables of the rendered content by html escaped values, unknown ones stay as is
+	Expand(rendered *RenderedPage, values map[string]string) *RenderedPage
+
+	// renders and sanitizes without the cache, returns removed elements
+	Render(contentType pb.ContentType, content string) (string, []string, error)
+
+	// accepts only content types having the renderer
+	ParseContentType(contentType string) (pb.ContentType, error)
+
+	// names of the registered content types
+	ContentTypes() []string
+
+	// drops the cached content of the page and its translations
+	Invalidate(name string)
+
+	// cache hits and misses since the start
+	CacheStats() (hits, misses int64)
+
+}
+
+type RenderedPage struct {
+	Content       string
+	LastModified  int64    // unix seconds
+	Removed       []string  // elements and attributes removed by HtmlSanitizer
+	Variables     []string  // names of {{.Name}} variables in the content, evaluated by Expand
+}
+
+var PageRendererClass = reflect.TypeOf((*PageRenderer)(nil)).Elem()
+
+// renderer of one content type, the output is sanitized by RenderService
+type PageRenderer interface {
+
+	ContentType() pb.ContentType
+
+	// returns html, error if the content is malformed
+	Render(content string) (string, error)
+
+}
+
+var FragmentServiceClass = reflect.TypeOf((*FragmentService)(nil)).Elem()
+
+// fragments are included in pages as {{include name}}, layouts wrap pages by header and footer fragments
+type FragmentService interface {
+
+	// ErrFragmentNotFound on error
+	GetFragment(ctx context.Context, name string) (*pb.FragmentEntity, error)
+
+	// checks the content and recursive includes
+	CreateFragment(ctx context.Context, fragment *pb.AdminFragment, authorId string) error
+
+	// ErrVersionConflict if the fragment was changed after the version
+	UpdateFragment(ctx context.Context, fragment *pb.AdminFragment, authorId string) error
+
+	// fragments used by layouts can not be removed
+	RemoveFragment(ctx context.Context, name string) error
+
+	EnumFragments(ctx context.Context, cb func(fragment *pb.FragmentEntity) bool) error
+
+	// ErrLayoutNotFound on error
+	GetLayout(ctx context.Context, name string) (*pb.LayoutEntity, error)
+
+	// creates or updates the layout, header and footer fragments must exist
+	SaveLayout(ctx context.Context, layout *pb.LayoutEntity, authorId string) error
+
+	RemoveLayout(ctx context.Context, name string) error
+
+	EnumLayouts(ctx context.Context, cb func(layout *pb.LayoutEntity) bool) error
+
+	// resolves includes of the rendered page and wraps it by the layout, page.default-layout if the name is empty,
+	// last modified time of the result is the latest of the used fragments
+	ComposePage(ctx context.Context, layout string, rendered *RenderedPage) (*RenderedPage, error)
+
+	// resolves only includes of the rendered page, without any layout
+	ResolveIncludes(ctx context.Context, rendered *RenderedPage) (*RenderedPage, error)
+
+}
+
+var PageSyncServiceClass = reflect.TypeOf((*PageSyncService)(nil)).Elem()
+
+// pages and translations as files with yaml front matter, like docs/intro.md and docs/intro.de.md
+type PageSyncService interface {
+
+	// files sorted by path
+	ExportPages(ctx context.Context) ([]*pb.PageFile, error)
+
+	// creates, updates and removes pages to match the files, dry run only returns the changes
+	ImportPages(ctx context.Context, files []*pb.PageFile, dryRun bool, authorId string) ([]*pb.PageChange, error)
+
+}
+
+var MediaServiceClass = reflect.TypeOf((*MediaService)(nil)).Elem()
+
+// uploaded images and documents referenced by pages as /media/<id>
+type MediaService interface {
+	glue.InitializingBean
+
+	// checks the size and the sniffed content type, the same file gets the same id
+	SaveMedia(ctx context.Context, fileName string, data []byte, createdBy string) (*pb.MediaEntity, error)
+
+	GetMedia(ctx context.Context, id string) (*pb.MediaEntity, error)
+
+	// returns content of the file or its thumbnail
+	ReadMedia(ctx context.Context, media *pb.MediaEntity, thumbnail bool) ([]byte, error)
+
+	RemoveMedia(ctx context.Context, id string) error
+
+	EnumMedia(ctx context.Context, cb func(media *pb.MediaEntity) bool) error
+
+}
+
+var TrafficServiceClass = reflect.TypeOf((*TrafficService)(nil)).Elem()
+
+// page views by hour and day, visitors are hashes of the IP and the user agent with the salt rotated daily
+type TrafficService interface {
+	glue.InitializingBean
+	glue.DisposableBean
+
+	// buffers the view of the public page, bots are skipped, raw IPs are never stored
+	RecordView(ctx context.Context, page, referrer, remoteIP, userAgent string, now time.Time)
+
+	// writes buffered views to hourly and daily buckets, runs in background every traffic.flush-interval-seconds
+	Flush(ctx context.Context) error
+
+	// points of the range between unix seconds with top pages and referrers
+	TrafficStats(ctx context.Context, fromTime, toTime int64, hourly bool, top int) (*pb.TrafficStats, error)
+
 }
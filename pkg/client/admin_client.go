@@ -20,6 +20,7 @@ import (
 	"github.com/codeallergy/template/pkg/api"
 	"github.com/codeallergy/template/pkg/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"sync"
 )
 
@@ -52,6 +53,29 @@ func (t *implAdminClient) AdminCommand(command string, args []string) (string, e
 	}
 }
 
+func (t *implAdminClient) ExportPages() ([]*pb.PageFile, error) {
+
+	if resp, err := t.client.ExportPages(context.Background(), &emptypb.Empty{}); err != nil {
+		return nil, err
+	} else {
+		return resp.Files, nil
+	}
+}
+
+func (t *implAdminClient) ImportPages(files []*pb.PageFile, dryRun bool) ([]*pb.PageChange, error) {
+
+	req := &pb.PageImport{
+		Files:  files,
+		DryRun: dryRun,
+	}
+
+	if resp, err := t.client.ImportPages(context.Background(), req); err != nil {
+		return nil, err
+	} else {
+		return resp.Changes, nil
+	}
+}
+
 func (t *implAdminClient) Destroy() (err error) {
 	t.closeOnce.Do(func() {
 		if t.GrpcConn != nil {
@@ -36,7 +36,7 @@ func (t *implAdminCommand) BeanName() string {
 }
 
 func (t *implAdminCommand) Desc() string {
-	return "admin commands: [list, add, remove]"
+	return "admin commands: [list, add, remove, verify-log, reindex]"
 }
 
 func (t *implAdminCommand) Run(args []string) error {
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// only files having the front matter are pages, so README.md and others in the same directory are kept
+const pageFrontMatter = "---\n"
+
+var pageFileExtensions = map[string]bool{
+	".md": true, ".html": true, ".txt": true, ".json": true,
+}
+
+type implPagesCommand struct {
+	Context           glue.Context             `inject`
+	Application       sprint.Application        `inject`
+	ApplicationFlags   sprint.ApplicationFlags   `inject`
+}
+
+func PagesCommand() sprint.Command {
+	return &implPagesCommand{}
+}
+
+func (t *implPagesCommand) BeanName() string {
+	return "pages"
+}
+
+func (t *implPagesCommand) Desc() string {
+	return "pages commands: [export dir, import dir [--dry-run]]"
+}
+
+func (t *implPagesCommand) Run(args []string) error {
+	if len(args) < 2 {
+		return errors.Errorf("pages command needs arguments, %s", t.Desc())
+	}
+	cmd, dir := args[0], args[1]
+
+	switch cmd {
+	case "export":
+		return doWithAdminClient(t.Context, func(client api.AdminClient) error {
+			return exportPages(client, dir)
+		})
+	case "import":
+		dryRun := len(args) > 2 && args[2] == "--dry-run"
+		return doWithAdminClient(t.Context, func(client api.AdminClient) error {
+			return importPages(client, dir, dryRun)
+		})
+	default:
+		return errors.Errorf("unknown pages command '%s', %s", cmd, t.Desc())
+	}
+}
+
+// writes all pages and removes page files of the deleted ones
+func exportPages(client api.AdminClient, dir string) error {
+
+	files, err := client.ExportPages()
+	if err != nil {
+		return err
+	}
+
+	exported := make(map[string]bool)
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(file.Content), 0644); err != nil {
+			return err
+		}
+		exported[file.Path] = true
+	}
+
+	stale, err := readPageFiles(dir)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, file := range stale {
+		if !exported[file.Path] {
+			if err := os.Remove(filepath.Join(dir, filepath.FromSlash(file.Path))); err != nil {
+				return err
+			}
+			println("removed " + file.Path)
+			removed++
+		}
+	}
+
+	println(fmt.Sprintf("OK, %d pages exported, %d removed", len(files), removed))
+	return nil
+}
+
+func importPages(client api.AdminClient, dir string, dryRun bool) error {
+
+	// missing directory would remove all pages
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+
+	files, err := readPageFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	changes, err := client.ImportPages(files, dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		println(change.Action + " " + change.Path)
+		if dryRun {
+			print(change.Diff)
+		}
+	}
+
+	if dryRun {
+		println(fmt.Sprintf("DRY RUN, %d changes", len(changes)))
+	} else {
+		println(fmt.Sprintf("OK, %d changes", len(changes)))
+	}
+	return nil
+}
+
+// page files with slash separated paths relative to the directory, hidden files and directories like .git are skipped
+func readPageFiles(dir string) ([]*pb.PageFile, error) {
+
+	var files []*pb.PageFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !pageFileExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(strings.ReplaceAll(string(content), "\r\n", "\n"), pageFrontMatter) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &pb.PageFile{Path: filepath.ToSlash(rel), Content: string(content)})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
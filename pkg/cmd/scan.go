@@ -16,5 +16,6 @@ package cmd
 
 var Commands = []interface{}{
 	AdminCommand(),
+	PagesCommand(),
 }
 
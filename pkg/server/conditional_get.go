@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	gatewayETagHeader           = "Grpc-Metadata-Etag"
+	gatewayLastModifiedHeader   = "Grpc-Metadata-Last-Modified"
+	gatewayLocationHeader       = "Grpc-Metadata-Location"
+	gatewayRedirectStatusHeader = "Grpc-Metadata-Redirect-Status"
+)
+
+// moves etag and last-modified from the grpc metadata to http headers and answers 304 on conditional requests,
+// moved pages are answered by 301 or 302 with the location header
+func conditionalGet(next http.Handler, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.HasPrefix(r.URL.Path, prefix) {
+			w = &conditionalWriter{ResponseWriter: w, req: r}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type conditionalWriter struct {
+	http.ResponseWriter
+	req          *http.Request
+	wroteHeader  bool
+	notModified  bool
+}
+
+func (t *conditionalWriter) WriteHeader(code int) {
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+
+	h := t.Header()
+
+	if location := h.Get(gatewayLocationHeader); location != "" {
+		redirectStatus, _ := strconv.Atoi(h.Get(gatewayRedirectStatusHeader))
+		h.Del(gatewayLocationHeader)
+		h.Del(gatewayRedirectStatusHeader)
+		if code == http.StatusOK && (redirectStatus == http.StatusMovedPermanently || redirectStatus == http.StatusFound) {
+			h.Set("Location", location)
+			code = redirectStatus
+		}
+	}
+
+	etag := h.Get(gatewayETagHeader)
+	lastModified := h.Get(gatewayLastModifiedHeader)
+
+	if etag != "" || lastModified != "" {
+		h.Del(gatewayETagHeader)
+		h.Del(gatewayLastModifiedHeader)
+		if etag != "" {
+			h.Set("ETag", etag)
+		}
+		if lastModified != "" {
+			h.Set("Last-Modified", lastModified)
+		}
+		// browser keeps the page, but asks the server every time
+		h.Set("Cache-Control", "no-cache")
+		// pages are translated by Accept-Language
+		h.Add("Vary", "Accept-Language")
+
+		if code == http.StatusOK && isNotModified(t.req, etag, lastModified) {
+			t.notModified = true
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			code = http.StatusNotModified
+		}
+	}
+
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *conditionalWriter) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	if t.notModified {
+		return len(b), nil
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// If-Modified-Since is ignored when If-None-Match is present, RFC 7232
+func isNotModified(r *http.Request, etag, lastModified string) bool {
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	since := r.Header.Get("If-Modified-Since")
+	if since == "" || lastModified == "" {
+		return false
+	}
+	sinceTime, err := http.ParseTime(since)
+	if err != nil {
+		return false
+	}
+	modifiedTime, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modifiedTime.After(sinceTime)
+}
@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/codeallergy/template/pkg/utils"
+	"go.uber.org/zap"
+	"html"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const feedPrefix = "/feed/"
+
+// atom feed of the category, rss.xml is next to it
+func feedURL(base, category string) string {
+	return strings.TrimRight(base, "/") + feedPrefix + category + "/atom.xml"
+}
+
+type atomLink struct {
+	Rel   string  `xml:"rel,attr,omitempty"`
+	Type  string  `xml:"type,attr,omitempty"`
+	Href  string  `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term  string  `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string  `xml:"type,attr"`
+	Text  string  `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title       string          `xml:"title"`
+	ID          string          `xml:"id"`
+	Link        atomLink        `xml:"link"`
+	Published   string          `xml:"published"`
+	Updated     string          `xml:"updated"`
+	Categories  []atomCategory  `xml:"category"`
+	Summary     atomText        `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name     `xml:"feed"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Links    []atomLink   `xml:"link"`
+	Updated  string       `xml:"updated"`
+	Entries  []atomEntry  `xml:"entry"`
+}
+
+type rssGuid struct {
+	IsPermaLink  bool    `xml:"isPermaLink,attr"`
+	Text         string  `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title        string    `xml:"title"`
+	Link         string    `xml:"link"`
+	Guid         rssGuid   `xml:"guid"`
+	PubDate      string    `xml:"pubDate"`
+	Categories   []string  `xml:"category"`
+	Description  string    `xml:"description"`
+}
+
+type rssChannel struct {
+	Title          string     `xml:"title"`
+	Link           string     `xml:"link"`
+	Description    string     `xml:"description"`
+	AtomLink       atomLink   `xml:"atom:link"`
+	LastBuildDate  string     `xml:"lastBuildDate"`
+	Items          []rssItem  `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName    xml.Name    `xml:"rss"`
+	Version    string      `xml:"version,attr"`
+	XmlnsAtom  string      `xml:"xmlns:atom,attr"`
+	Channel    rssChannel  `xml:"channel"`
+}
+
+// feed entry of the page
+type feedItem struct {
+	page       *pb.PageEntity
+	url        string
+	published  time.Time
+	updated    time.Time
+	summary    string
+}
+
+// serves /feed/<category>/atom.xml and /feed/<category>/rss.xml with public pages of the category
+// by the publish date, the latest first
+type implFeedPage struct {
+	PageService      api.PageService      `inject`
+	RenderService    api.RenderService    `inject`
+	FragmentService  api.FragmentService  `inject`
+	Log              *zap.Logger          `inject`
+
+	WebappURL   string  `value:"webapp.url,default=https://localhost:8443"`
+	WebappName  string  `value:"webapp.name,default=Light-Template"`
+	MaxAge      int     `value:"seo.max-age,default=3600"`
+	MaxItems    int     `value:"feed.max-items,default=20"`
+}
+
+func FeedPage() sprint.Page {
+	return &implFeedPage{}
+}
+
+func (t *implFeedPage) Pattern() string {
+	return feedPrefix
+}
+
+func (t *implFeedPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	category, format := pathDir(strings.TrimPrefix(r.URL.Path, feedPrefix))
+	if category == "" || category != utils.NormalizeIdentityField(category) || (format != "atom.xml" && format != "rss.xml") {
+		http.NotFound(w, r)
+		return
+	}
+
+	items, err := t.feedItems(r, category)
+	if err != nil {
+		t.Log.Error("FeedPage", zap.String("category", category), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(items) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var updated time.Time
+	for _, item := range items {
+		if item.updated.After(updated) {
+			updated = item.updated
+		}
+	}
+
+	var feed interface{}
+	contentType := "application/atom+xml; charset=utf-8"
+	if format == "atom.xml" {
+		feed = t.atom(category, items, updated)
+	} else {
+		feed = t.rss(category, items, updated)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+
+	content, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		t.Log.Error("FeedPage", zap.String("category", category), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", t.MaxAge))
+
+	// handles If-Modified-Since
+	http.ServeContent(w, r, "", updated, bytes.NewReader(append([]byte(xml.Header), content...)))
+}
+
+// splits 'news/atom.xml' to 'news' and 'atom.xml'
+func pathDir(path string) (string, string) {
+	if i := strings.LastIndexByte(path, '/'); i != -1 {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
+
+func (t *implFeedPage) feedItems(r *http.Request, category string) ([]*feedItem, error) {
+
+	now := time.Now().Unix()
+	var pages []*pb.PageEntity
+	err := t.PageService.EnumPages(r.Context(), func(page *pb.PageEntity) bool {
+		if page.Category == category && service.IsPagePublic(page, now) && service.CanViewPage(page, nil) {
+			pages = append(pages, page)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*feedItem
+	for _, page := range pages {
+		published := page.PublishAt
+		if published == 0 {
+			published = page.CreTimestamp
+		}
+		updated := page.UpdTimestamp
+		if updated < published {
+			updated = published
+		}
+		items = append(items, &feedItem{
+			page:      page,
+			url:       pageURL(t.WebappURL, page.Name),
+			published: time.Unix(published, 0).UTC(),
+			updated:   time.Unix(updated, 0).UTC(),
+		})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].published.Equal(items[j].published) {
+			return items[i].published.After(items[j].published)
+		}
+		return items[i].page.Name < items[j].page.Name
+	})
+	if len(items) > t.MaxItems {
+		items = items[:t.MaxItems]
+	}
+
+	// feed readers are guests, so only site wide variables are evaluated
+	values := map[string]string{
+		"FirstName": "",
+		"Project":   t.WebappName,
+		"Url":       t.WebappURL,
+		"Year":      strconv.Itoa(time.Now().Year()),
+	}
+	for _, item := range items {
+		rendered, err := t.RenderService.RenderPage(item.page)
+		if err != nil {
+			return nil, err
+		}
+		// the header of the layout must not be the summary
+		rendered, err = t.FragmentService.ResolveIncludes(r.Context(), rendered)
+		if err != nil {
+			return nil, err
+		}
+		item.summary = feedSummary(item.page, t.RenderService.Expand(rendered, values).Content)
+	}
+
+	return items, nil
+}
+
+var firstParagraphRe = regexp.MustCompile(`(?s)<p>.*?</p>`)
+
+// html summary, the meta description or the first paragraph of the rendered content
+func feedSummary(page *pb.PageEntity, content string) string {
+	if page.Description != "" {
+		return html.EscapeString(page.Description)
+	}
+	if p := firstParagraphRe.FindString(content); p != "" {
+		return p
+	}
+	return content
+}
+
+func (t *implFeedPage) atom(category string, items []*feedItem, updated time.Time) *atomFeed {
+
+	self := feedURL(t.WebappURL, category)
+	feed := &atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Title:   fmt.Sprintf("%s: %s", t.WebappName, category),
+		ID:      self,
+		Links:   []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: strings.TrimRight(t.WebappURL, "/") + "/"},
+		},
+		Updated: updated.Format(time.RFC3339),
+	}
+
+	for _, item := range items {
+		entry := atomEntry{
+			Title:     item.page.Title,
+			ID:        item.url,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: item.url},
+			Published: item.published.Format(time.RFC3339),
+			Updated:   item.updated.Format(time.RFC3339),
+			Summary:   atomText{Type: "html", Text: item.summary},
+		}
+		for _, tag := range item.page.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func (t *implFeedPage) rss(category string, items []*feedItem, updated time.Time) *rssFeed {
+
+	feed := &rssFeed{
+		Version:   "2.0",
+		XmlnsAtom: "http://www.w3.org/2005/Atom",
+		Channel:   rssChannel{
+			Title:         fmt.Sprintf("%s: %s", t.WebappName, category),
+			Link:          strings.TrimRight(t.WebappURL, "/") + "/",
+			Description:   fmt.Sprintf("Pages of %s in %s", category, t.WebappName),
+			AtomLink:      atomLink{Rel: "self", Type: "application/rss+xml", Href: strings.TrimRight(t.WebappURL, "/") + feedPrefix + category + "/rss.xml"},
+			LastBuildDate: updated.Format(time.RFC1123Z),
+		},
+	}
+
+	for _, item := range items {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       item.page.Title,
+			Link:        item.url,
+			Guid:        rssGuid{IsPermaLink: true, Text: item.url},
+			PubDate:     item.published.Format(time.RFC1123Z),
+			Categories:  item.page.Tags,
+			Description: item.summary,
+		})
+	}
+	return feed
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server_test
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/server"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFeedPage(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+	fragmentService := service.FragmentService()
+	feedPage := server.FeedPage()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore,
+		service.HtmlSanitizer(), service.RenderService(),
+		service.MarkdownRenderer(),
+		service.HtmlRenderer(),
+		pageService, fragmentService, feedPage)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	err = fragmentService.CreateFragment(bg, &pb.AdminFragment{Name: "banner", Content: "<p>Banner</p>", ContentType: "HTML"}, "admin")
+	require.NoError(t, err)
+
+	err = fragmentService.CreateFragment(bg, &pb.AdminFragment{Name: "intro", Content: "Intro *text*", ContentType: "MARKDOWN"}, "admin")
+	require.NoError(t, err)
+
+	err = fragmentService.SaveLayout(bg, &pb.LayoutEntity{Name: "main", Header: "banner"}, "admin")
+	require.NoError(t, err)
+
+	err = pageService.CreatePage(bg, &pb.AdminPage{Name: "first", Title: "First", Content: "{{include intro}}\n\nBody", ContentType: "MARKDOWN", Status: "PUBLISHED", Layout: "main", Category: "news"}, "admin")
+	require.NoError(t, err)
+
+	// the summary is the first paragraph of the page, not of the layout header
+	w := httptest.NewRecorder()
+	feedPage.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/feed/news/atom.xml", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "&lt;p&gt;Intro &lt;em&gt;text&lt;/em&gt;&lt;/p&gt;")
+	require.NotContains(t, w.Body.String(), "Banner")
+
+}
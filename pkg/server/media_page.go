@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/service"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const mediaPrefix = "/media/"
+
+// serves /media/<id> and /media/<id>/thumbnail, the id is the checksum, so the content never changes
+type implMediaPage struct {
+	MediaService  api.MediaService  `inject`
+	Log           *zap.Logger       `inject`
+}
+
+func MediaPage() sprint.Page {
+	return &implMediaPage{}
+}
+
+func (t *implMediaPage) Pattern() string {
+	return mediaPrefix
+}
+
+func (t *implMediaPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, mediaPrefix)
+	thumbnail := strings.HasSuffix(id, "/thumbnail")
+	id = strings.TrimSuffix(id, "/thumbnail")
+
+	media, err := t.MediaService.GetMedia(r.Context(), id)
+	if err == nil {
+		var data []byte
+		data, err = t.MediaService.ReadMedia(r.Context(), media, thumbnail)
+		if err == nil {
+			contentType, etag := media.ContentType, media.Checksum
+			if thumbnail {
+				contentType, etag = http.DetectContentType(data), etag + "-thumbnail"
+			}
+
+			h := w.Header()
+			h.Set("Content-Type", contentType)
+			h.Set("ETag", fmt.Sprintf(`"%s"`, etag))
+			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+			h.Set("X-Content-Type-Options", "nosniff")
+			if !strings.HasPrefix(contentType, "image/") && media.FileName != "" {
+				h.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", media.FileName))
+			}
+
+			// handles If-None-Match, If-Modified-Since and ranges
+			http.ServeContent(w, r, "", time.Unix(media.CreTimestamp, 0), bytes.NewReader(data))
+			return
+		}
+	}
+
+	if err == service.ErrMediaNotFound {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Log.Error("MediaPage", zap.String("path", r.URL.Path), zap.Error(err))
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
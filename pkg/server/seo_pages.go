@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// public url of the page in the webapp
+func pageURL(base, name string) string {
+	return strings.TrimRight(base, "/") + "/static?page=" + strings.ReplaceAll(url.QueryEscape(name), "%2F", "/")
+}
+
+// translations have the locale parameter
+func localizedPageURL(base string, page *pb.PageEntity) string {
+	if page.Locale == "" {
+		return pageURL(base, page.Name)
+	}
+	return pageURL(base, page.Name) + "&locale=" + url.QueryEscape(page.Locale)
+}
+
+// site relative urls are resolved against webapp.url, crawlers need absolute ones
+func absoluteURL(base, u string) string {
+	if strings.HasPrefix(u, "/") {
+		return strings.TrimRight(base, "/") + u
+	}
+	return u
+}
+
+type sitemapURL struct {
+	Loc      string  `xml:"loc"`
+	LastMod  string  `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName  xml.Name      `xml:"urlset"`
+	Xmlns    string        `xml:"xmlns,attr"`
+	URLs     []sitemapURL  `xml:"url"`
+}
+
+// public pages visible to guests and their translations without noindex flag, pages having the canonical url are listed by that url
+type implSitemapPage struct {
+	PageService  api.PageService  `inject`
+	Log          *zap.Logger      `inject`
+
+	WebappURL    string  `value:"webapp.url,default=https://localhost:8443"`
+	MaxAge       int     `value:"seo.max-age,default=3600"`
+	NotFoundPage string  `value:"page.not-found,default=not-found"`
+}
+
+func SitemapPage() sprint.Page {
+	return &implSitemapPage{}
+}
+
+func (t *implSitemapPage) Pattern() string {
+	return "/sitemap.xml"
+}
+
+func (t *implSitemapPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	set := &sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		URLs:  []sitemapURL{{Loc: strings.TrimRight(t.WebappURL, "/") + "/"}},
+	}
+
+	now := time.Now().Unix()
+	var pages []*pb.PageEntity
+	err := t.PageService.EnumPages(r.Context(), func(page *pb.PageEntity) bool {
+		if page.Name != t.NotFoundPage && service.IsPagePublic(page, now) && service.CanViewPage(page, nil) {
+			pages = append(pages, page)
+		}
+		return true
+	})
+	if err != nil {
+		t.Log.Error("SitemapPage", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	seen := make(map[string]bool)
+	add := func(page *pb.PageEntity) {
+		if page.Noindex {
+			return
+		}
+		loc := localizedPageURL(t.WebappURL, page)
+		if page.CanonicalUrl != "" {
+			loc = absoluteURL(t.WebappURL, page.CanonicalUrl)
+		}
+		if seen[loc] {
+			return
+		}
+		seen[loc] = true
+
+		lastModified := page.UpdTimestamp
+		if lastModified == 0 {
+			lastModified = page.CreTimestamp
+		}
+		item := sitemapURL{Loc: loc}
+		if lastModified > 0 {
+			item.LastMod = time.Unix(lastModified, 0).UTC().Format("2006-01-02")
+		}
+		set.URLs = append(set.URLs, item)
+	}
+
+	for _, page := range pages {
+		add(page)
+
+		locales, err := t.PageService.PageLocales(r.Context(), page.Name)
+		if err != nil {
+			t.Log.Error("SitemapPage", zap.Error(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		for _, locale := range locales {
+			translation, err := t.PageService.GetPageLocale(r.Context(), page.Name, locale)
+			if err == nil && service.IsPagePublic(translation, now) {
+				add(translation)
+			}
+		}
+	}
+
+	content, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		t.Log.Error("SitemapPage", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", t.MaxAge))
+	w.Write([]byte(xml.Header))
+	w.Write(content)
+}
+
+// disallows private sections and noindex pages, points crawlers to the sitemap
+type implRobotsPage struct {
+	PageService  api.PageService  `inject`
+	Log          *zap.Logger      `inject`
+
+	WebappURL    string    `value:"webapp.url,default=https://localhost:8443"`
+	MaxAge       int       `value:"seo.max-age,default=3600"`
+	Disallow     []string  `value:"seo.robots-disallow,default=/admin/;/api/;/auth/;/profile/"`
+}
+
+func RobotsPage() sprint.Page {
+	return &implRobotsPage{}
+}
+
+func (t *implRobotsPage) Pattern() string {
+	return "/robots.txt"
+}
+
+func (t *implRobotsPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var out strings.Builder
+	out.WriteString("User-agent: *\n")
+	for _, path := range t.Disallow {
+		out.WriteString(fmt.Sprintf("Disallow: %s\n", path))
+	}
+
+	now := time.Now().Unix()
+	err := t.PageService.EnumPages(r.Context(), func(page *pb.PageEntity) bool {
+		if page.Noindex && service.IsPagePublic(page, now) {
+			// '$' stops the prefix match on pages with the same beginning
+			out.WriteString(fmt.Sprintf("Disallow: %s$\n", pageURL("", page.Name)))
+		}
+		return true
+	})
+	if err != nil {
+		t.Log.Error("RobotsPage", zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	out.WriteString(fmt.Sprintf("\nSitemap: %s/sitemap.xml\n", strings.TrimRight(t.WebappURL, "/")))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", t.MaxAge))
+	w.Write([]byte(out.String()))
+}
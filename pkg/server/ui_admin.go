@@ -18,12 +18,17 @@ import (
 	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/codeallergy/template/pkg/api"
 	"github.com/codeallergy/template/pkg/pb"
 	"github.com/codeallergy/template/pkg/service"
+	"github.com/codeallergy/template/pkg/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"sort"
 	"strings"
+	"time"
 )
 
 func (t *implUIGrpcServer) AdminPageScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminPageScanResponse, err error) {
@@ -58,6 +63,15 @@ func (t *implUIGrpcServer) AdminPageScan(ctx context.Context, req *pb.AdminScanR
 				Name:         page.Name,
 				Title:        page.Title,
 				CreatedAt:    page.CreTimestamp,
+				Status:       page.Status.String(),
+				PublishAt:    page.PublishAt,
+				UnpublishAt:  page.UnpublishAt,
+				UpdatedAt:    page.UpdTimestamp,
+				CreatedBy:    page.CreatedBy,
+				UpdatedBy:    page.UpdatedBy,
+				SortOrder:    page.SortOrder,
+				Visibility:   page.Visibility.String(),
+				Category:     page.Category,
 			})
 			limit--
 		}
@@ -69,6 +83,13 @@ func (t *implUIGrpcServer) AdminPageScan(ctx context.Context, req *pb.AdminScanR
 		return nil, err
 	}
 
+	for _, item := range items {
+		item.Locales, err = t.PageService.PageLocales(ctx, item.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &pb.AdminPageScanResponse{Items: items, Total: int32(total)}, nil
 
 }
@@ -88,22 +109,53 @@ func (t *implUIGrpcServer) AdminCreatePage(ctx context.Context, req *pb.AdminPag
 
 	}()
 
-	err = t.PageService.CreatePage(ctx, req)
-	return &emptypb.Empty{}, err
+	err = t.checkLayout(ctx, req.Layout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.PageService.CreatePage(ctx, req, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := t.PageService.GetPageLocale(ctx, req.Name, req.Locale)
+	t.logAudit(ctx, user.Username, "AdminCreatePage", localizedName(req.Name, req.Locale), nil, after)
+	return &emptypb.Empty{}, nil
 
 }
 
-func (t *implUIGrpcServer) parseContentType(ct string) (pb.ContentType, error) {
-	contentType := pb.ContentType_MARKDOWN
-	switch strings.ToUpper(strings.TrimSpace(ct)) {
-	case "MARKDOWN":
-		contentType = pb.ContentType_MARKDOWN
-	case "HTML":
-		contentType = pb.ContentType_HTML
-	default:
-		return 0, errors.Errorf("invalid content type '%s'", ct)
+func (t *implUIGrpcServer) AdminPreviewPage(ctx context.Context, req *pb.AdminPage) (*pb.PageContent, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	resp := &pb.PageContent{
+		Title:        req.Title,
+		ContentTypes: t.RenderService.ContentTypes(),
+	}
+
+	contentType, err := t.RenderService.ParseContentType(req.ContentType)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	content, _, err := t.RenderService.Render(contentType, req.Content)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	composed, err := t.FragmentService.ComposePage(ctx, req.Layout, &api.RenderedPage{Content: content})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
 	}
-	return contentType, nil
+
+	values, _ := t.pageVariables(ctx, pageVariableNames)
+	resp.Content = t.RenderService.Expand(composed, values).Content
+
+	return resp, nil
 }
 
 func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (*pb.AdminPage, error) {
@@ -113,7 +165,7 @@ func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (
 		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	page, err := t.PageService.GetPage(ctx, req.Name)
+	page, err := t.PageService.GetPageLocale(ctx, req.Name, req.Locale)
 	if err == service.ErrPageNotFound {
 		return nil, status.Errorf(codes.NotFound, "page not found")
 	}
@@ -121,11 +173,39 @@ func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (
 		return nil, t.wrapError(err, "AdminGetPage", user.Username)
 	}
 
+	locales, err := t.PageService.PageLocales(ctx, page.Name)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminGetPage", user.Username)
+	}
+
 	return &pb.AdminPage{
 		Name:        page.Name,
 		Title:       page.Title,
 		Content:     page.Content,
 		ContentType: page.ContentType.String(),
+		Status:      page.Status.String(),
+		PublishAt:   page.PublishAt,
+		UnpublishAt: page.UnpublishAt,
+		CreatedAt:   page.CreTimestamp,
+		UpdatedAt:   page.UpdTimestamp,
+		CreatedBy:   page.CreatedBy,
+		UpdatedBy:   page.UpdatedBy,
+		Version:     page.Version,
+		SortOrder:   page.SortOrder,
+		ContentTypes: t.RenderService.ContentTypes(),
+		Description:  page.Description,
+		Keywords:     page.Keywords,
+		OgTitle:      page.OgTitle,
+		OgImage:      page.OgImage,
+		CanonicalUrl: page.CanonicalUrl,
+		Noindex:      page.Noindex,
+		Locale:       page.Locale,
+		Locales:      locales,
+		Layout:       page.Layout,
+		Visibility:   page.Visibility.String(),
+		Roles:        page.Roles,
+		Category:     page.Category,
+		Tags:         page.Tags,
 	}, nil
 
 }
@@ -145,8 +225,25 @@ func (t *implUIGrpcServer) AdminUpdatePage(ctx context.Context, req *pb.AdminPag
 
 	}()
 
-	err = t.PageService.UpdatePage(ctx, req)
-	return &emptypb.Empty{}, err
+	prev := req.Name
+	if req.Prev != "" {
+		prev = req.Prev
+	}
+	before, _ := t.PageService.GetPageLocale(ctx, prev, req.Locale)
+
+	err = t.checkLayout(ctx, req.Layout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.PageService.UpdatePage(ctx, req, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := t.PageService.GetPageLocale(ctx, req.Name, req.Locale)
+	t.logAudit(ctx, user.Username, "AdminUpdatePage", localizedName(req.Name, req.Locale), before, after)
+	return &emptypb.Empty{}, nil
 
 }
 
@@ -157,16 +254,81 @@ func (t *implUIGrpcServer) AdminDeletePage(ctx context.Context, req *pb.PageName
 		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	err := t.PageService.RemovePage(ctx, req.Name)
+	before, _ := t.PageService.GetPageLocale(ctx, req.Name, req.Locale)
+
+	var err error
+	if req.Locale != "" {
+		err = t.PageService.RemovePageLocale(ctx, req.Name, req.Locale)
+	} else {
+		err = t.PageService.RemovePage(ctx, req.Name)
+	}
 	if err != nil {
 		return nil, t.wrapError(err, "AdminDeletePage", user.Username)
 	}
 
+	t.logAudit(ctx, user.Username, "AdminDeletePage", localizedName(req.Name, req.Locale), before, nil)
 	return &emptypb.Empty{}, nil
 
 }
 
-func (t *implUIGrpcServer) AdminUserScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminUserScanResponse, err error) {
+func (t *implUIGrpcServer) AdminPageRevisions(ctx context.Context, req *pb.PageName) (*pb.AdminPageRevisionsResponse, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	var items []*pb.PageRevisionItem
+	err := t.PageService.EnumPageRevisions(ctx, req.Name, func(rev *pb.PageRevisionEntity) bool {
+		items = append(items, &pb.PageRevisionItem{
+			Revision:  rev.Revision,
+			Title:     rev.Title,
+			AuthorId:  rev.AuthorId,
+			CreatedAt: rev.CreTimestamp,
+			Note:      rev.Note,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, t.wrapError(err, "AdminPageRevisions", user.Username)
+	}
+
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return &pb.AdminPageRevisionsResponse{Name: req.Name, Items: items}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminGetPageRevision(ctx context.Context, req *pb.PageRevisionRequest) (*pb.AdminPageRevision, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	rev, err := t.PageService.GetPageRevision(ctx, req.Name, req.Revision)
+	if err == service.ErrPageRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision %d of page '%s' not found", req.Revision, req.Name)
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "AdminGetPageRevision", user.Username)
+	}
+
+	return &pb.AdminPageRevision{
+		Revision:    rev.Revision,
+		Title:       rev.Title,
+		Content:     rev.Content,
+		ContentType: rev.ContentType.String(),
+		AuthorId:    rev.AuthorId,
+		CreatedAt:   rev.CreTimestamp,
+		Note:        rev.Note,
+	}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminPageDiff(ctx context.Context, req *pb.PageDiffRequest) (resp *pb.PageDiffResponse, err error) {
 
 	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
 	if !ok || !user.Roles["WEB_ADMIN"] {
@@ -176,178 +338,1016 @@ func (t *implUIGrpcServer) AdminUserScan(ctx context.Context, req *pb.AdminScanR
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "AdminUserScan", user.Username)
+			err = t.wrapError(err, "AdminPageDiff", user.Username)
 		}
 
 	}()
 
-	offset := int(req.Offset)
-	if offset < 0 {
-		offset = 0
+	toRevision := req.ToRevision
+	if toRevision == 0 {
+		err = t.PageService.EnumPageRevisions(ctx, req.Name, func(rev *pb.PageRevisionEntity) bool {
+			toRevision = rev.Revision
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	limit := int(req.Limit)
 
-	var total int
-	var items []*pb.UserItem
-	err = t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
-		if offset > 0 {
-			offset--
-		} else if limit > 0 {
-			items = append(items, &pb.UserItem{
-				Position:     int32(total + 1),
-				Id:           user.UserId,
-				Email:        user.Email,
-				FullName:     getFullName(user),
-				Role:         user.Role.String(),
-				CreatedAt:    user.CreTimestamp,
-			})
-			limit--
-		}
-		total++
-		return true
-	})
+	fromRevision := req.FromRevision
+	if fromRevision == 0 {
+		fromRevision = toRevision - 1
+	}
 
+	from, err := t.PageService.GetPageRevision(ctx, req.Name, fromRevision)
+	if err == service.ErrPageRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision %d of page '%s' not found", fromRevision, req.Name)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.AdminUserScanResponse{Items: items, Total: int32(total)}, nil
+	to, err := t.PageService.GetPageRevision(ctx, req.Name, toRevision)
+	if err == service.ErrPageRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision %d of page '%s' not found", toRevision, req.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []*pb.PageDiffLine
+	for _, line := range utils.LineDiff(revisionText(from), revisionText(to)) {
+		lines = append(lines, &pb.PageDiffLine{
+			Op:   string(line.Op),
+			Text: line.Text,
+		})
+	}
+
+	return &pb.PageDiffResponse{
+		FromRevision: fromRevision,
+		ToRevision:   toRevision,
+		Lines:        lines,
+	}, nil
 
 }
 
-func (t *implUIGrpcServer) AdminGetUser(ctx context.Context, req *pb.UserId) (*pb.AdminUser, error) {
+// title and content type are compared as the header lines
+func revisionText(rev *pb.PageRevisionEntity) string {
+	return fmt.Sprintf("Title: %s\nContent-Type: %s\n\n%s", rev.Title, rev.ContentType.String(), rev.Content)
+}
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
+func (t *implUIGrpcServer) AdminRestorePage(ctx context.Context, req *pb.PageRevisionRequest) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
 		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	user, err := t.UserService.GetUser(ctx, req.Id)
-	if err == service.ErrUserNotFound {
-		return nil, status.Errorf(codes.NotFound, "user not found")
+	before, _ := t.PageService.GetPage(ctx, req.Name)
+
+	err := t.PageService.RestorePageRevision(ctx, req.Name, req.Revision, user.Username)
+	if err == service.ErrPageRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision %d of page '%s' not found", req.Revision, req.Name)
 	}
 	if err != nil {
-		return nil, t.wrapError(err, "AdminGetUser", req.Id)
+		return nil, t.wrapError(err, "AdminRestorePage", user.Username)
 	}
 
-	return &pb.AdminUser{
-		Id:        user.UserId,
-		Email:     user.Email,
-		FullName:  getFullName(user),
-		CreatedAt: user.CreTimestamp,
-		Role: user.Role.String(),
-	}, nil
+	after, _ := t.PageService.GetPage(ctx, req.Name)
+	t.logAudit(ctx, user.Username, "AdminRestorePage", fmt.Sprintf("%s@%d", req.Name, req.Revision), before, after)
+	return &emptypb.Empty{}, nil
 
 }
 
-func (t *implUIGrpcServer) AdminUpdateUser(ctx context.Context, req *pb.AdminUser) (resp *emptypb.Empty, err error) {
-
-	resp = &emptypb.Empty{}
+func (t *implUIGrpcServer) AdminMovePage(ctx context.Context, req *pb.MovePageRequest) (*emptypb.Empty, error) {
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
 		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	if admin.Username == req.Id {
-		return nil, status.Errorf(codes.Unauthenticated, "self role change not permitted")
-	}
-
-	var pbRole pb.UserRole
-	role := strings.ToUpper(strings.TrimSpace(req.Role))
-	switch role {
-	case "USER":
-		pbRole = pb.UserRole_USER
-	case "ADMIN":
-		pbRole = pb.UserRole_ADMIN
-	default :
-		return nil, status.Errorf(codes.InvalidArgument, "unknown role '%s'", role)
+	err := t.PageService.MovePage(ctx, req.Name, req.NewName, user.Username)
+	if err == service.ErrPageNotFound {
+		return nil, status.Errorf(codes.NotFound, "page '%s' not found", req.Name)
 	}
-
-	err = t.UserService.DoWithUser(ctx, req.Id, func(user *pb.UserEntity) error {
-		user.Role = pbRole
-		return nil
-	})
 	if err != nil {
-		err = t.wrapError(err, "AdminUpdateUser", req.Id)
+		return nil, t.wrapError(err, "AdminMovePage", user.Username)
 	}
 
-	return
-}
+	t.logAudit(ctx, user.Username, "AdminMovePage", fmt.Sprintf("%s -> %s", req.Name, req.NewName), nil, nil)
+	return &emptypb.Empty{}, nil
 
-func (t *implUIGrpcServer) AdminDeleteUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+}
 
-	resp = &emptypb.Empty{}
+func (t *implUIGrpcServer) AdminReorderPages(ctx context.Context, req *pb.ReorderPagesRequest) (*emptypb.Empty, error) {
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
 		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	err = t.UserService.RemoveUser(ctx, req.Id)
+	err := t.PageService.ReorderPages(ctx, req.Parent, req.Names)
 	if err != nil {
-		err = t.wrapError(err, "AdminDeleteUser", req.Id)
-	} else {
-		err = t.UserService.DropUserContent(context.Background(), req.Id)
-		if err != nil {
-			err = t.wrapError(err, "DropUserContent", req.Id)
-		}
+		return nil, t.wrapError(err, "AdminReorderPages", user.Username)
 	}
 
-	return
+	t.logAudit(ctx, user.Username, "AdminReorderPages", strings.TrimSpace(req.Parent + " " + strings.Join(req.Names, ",")), nil, nil)
+	return &emptypb.Empty{}, nil
+
 }
 
-func (t *implUIGrpcServer) AdminRun(ctx context.Context, req *pb.Command)  (*pb.CommandResult, error) {
+func (t *implUIGrpcServer) AdminRedirectScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminRedirectScanResponse, err error) {
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role ADMIN is required")
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
 	}
 
-	switch req.Command {
-	case "add":
-		return t.setUserRole(ctx, req, pb.UserRole_ADMIN)
-	case "remove":
-		return t.setUserRole(ctx, req, pb.UserRole_USER)
-	case "list":
-		var out strings.Builder
-		err := t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
-			if user.Role == pb.UserRole_ADMIN {
-				out.WriteString(fmt.Sprintf("%s, %s, ADMIN\n", user.Email, getFullName(user)))
-			}
-			return true
-		})
+	defer func() {
+
 		if err != nil {
-			return nil, t.wrapError(err, "AdminRun", admin.Username)
+			err = t.wrapError(err, "AdminRedirectScan", user.Username)
 		}
-		return &pb.CommandResult{Content: out.String()}, err
-	default:
-		return nil, status.Errorf(codes.InvalidArgument, "unknown command '%s', allowed commands 'add,remove,list'", req.Command)
-	}
 
-}
+	}()
 
-func (t *implUIGrpcServer) setUserRole(ctx context.Context, req *pb.Command, role pb.UserRole) (*pb.CommandResult, error) {
-	if len(req.Args) == 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "command needs email argument")
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
 	}
-	email := req.Args[0]
+	limit := int(req.Limit)
+
+	var total int
+	var items []*pb.RedirectItem
+	err = t.PageService.EnumRedirects(ctx, func(redirect *pb.PageRedirectEntity) bool {
+		if offset > 0 {
+			offset--
+		} else if limit > 0 {
+			items = append(items, &pb.RedirectItem{
+				Position:   int32(total + 1),
+				From:       redirect.From,
+				To:         redirect.To,
+				Temporary:  redirect.Temporary,
+				CreatedAt:  redirect.CreTimestamp,
+				CreatedBy:  redirect.CreatedBy,
+			})
+			limit--
+		}
+		total++
+		return true
+	})
 
-	userId, err := t.UserService.GetUserIdByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.UserService.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
-		user.Role = role
-		return nil
-	})
-	if err == service.ErrUserNotFound {
-		return nil, status.Errorf(codes.NotFound, "user '%s' not found", email)
+	return &pb.AdminRedirectScanResponse{Items: items, Total: int32(total)}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminSaveRedirect(ctx context.Context, req *pb.RedirectItem) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	redirect := &pb.PageRedirectEntity{
+		From:      req.From,
+		To:        req.To,
+		Temporary: req.Temporary,
+		CreatedBy: user.Username,
 	}
+
+	err := t.PageService.SaveRedirect(ctx, redirect)
 	if err != nil {
-		return nil, t.wrapError(err, "setUserRole", email)
+		return nil, t.wrapError(err, "AdminSaveRedirect", user.Username)
 	}
 
-	return &pb.CommandResult{Content: "OK"}, nil
+	t.logAudit(ctx, user.Username, "AdminSaveRedirect", redirect.From, nil, redirect)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminDeleteRedirect(ctx context.Context, req *pb.RedirectPath) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	err := t.PageService.RemoveRedirect(ctx, req.From)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminDeleteRedirect", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminDeleteRedirect", req.From, nil, nil)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminMediaScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminMediaScanResponse, err error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminMediaScan", user.Username)
+		}
+
+	}()
+
+	var list []*pb.MediaEntity
+	err = t.MediaService.EnumMedia(ctx, func(media *pb.MediaEntity) bool {
+		list = append(list, media)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are checksums, recent uploads go first
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].CreTimestamp > list[j].CreTimestamp
+	})
+
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+
+	var items []*pb.MediaItem
+	for i := offset; i < len(list) && len(items) < int(req.Limit); i++ {
+		item := toMediaItem(list[i])
+		item.Position = int32(i + 1)
+		items = append(items, item)
+	}
+
+	return &pb.AdminMediaScanResponse{Items: items, Total: int32(len(list))}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminUploadMedia(ctx context.Context, req *pb.MediaUpload) (*pb.MediaItem, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	media, err := t.MediaService.SaveMedia(ctx, req.FileName, req.Data, user.Username)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminUploadMedia", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminUploadMedia", media.Id, nil, media)
+	return toMediaItem(media), nil
+
+}
+
+func (t *implUIGrpcServer) AdminDeleteMedia(ctx context.Context, req *pb.MediaId) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	media, err := t.MediaService.GetMedia(ctx, req.Id)
+	if err == service.ErrMediaNotFound {
+		return nil, status.Errorf(codes.NotFound, "media '%s' not found", req.Id)
+	}
+	if err == nil {
+		err = t.MediaService.RemoveMedia(ctx, req.Id)
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "AdminDeleteMedia", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminDeleteMedia", req.Id, media, nil)
+	return &emptypb.Empty{}, nil
+
+}
+
+// audit target of the translation
+func localizedName(name, locale string) string {
+	if locale == "" {
+		return name
+	}
+	return name + ":" + locale
+}
+
+func toMediaItem(media *pb.MediaEntity) *pb.MediaItem {
+	item := &pb.MediaItem{
+		Id:          media.Id,
+		FileName:    media.FileName,
+		ContentType: media.ContentType,
+		Size:        media.Size,
+		Checksum:    media.Checksum,
+		Width:       media.Width,
+		Height:      media.Height,
+		Url:         mediaPrefix + media.Id,
+		CreatedAt:   media.CreTimestamp,
+		CreatedBy:   media.CreatedBy,
+	}
+	if media.Thumbnail {
+		item.ThumbnailUrl = item.Url + "/thumbnail"
+	}
+	return item
+}
+
+func (t *implUIGrpcServer) AdminUserScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminUserScanResponse, err error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminUserScan", user.Username)
+		}
+
+	}()
+
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	limit := int(req.Limit)
+
+	var total int
+	var items []*pb.UserItem
+	err = t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
+		if offset > 0 {
+			offset--
+		} else if limit > 0 {
+			items = append(items, &pb.UserItem{
+				Position:     int32(total + 1),
+				Id:           user.UserId,
+				Email:        user.Email,
+				FullName:     getFullName(user),
+				Role:         user.Role.String(),
+				CreatedAt:    user.CreTimestamp,
+			})
+			limit--
+		}
+		total++
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AdminUserScanResponse{Items: items, Total: int32(total)}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminGetUser(ctx context.Context, req *pb.UserId) (*pb.AdminUser, error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	user, err := t.UserService.GetUser(ctx, req.Id)
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "AdminGetUser", req.Id)
+	}
+
+	return &pb.AdminUser{
+		Id:        user.UserId,
+		Email:     user.Email,
+		FullName:  getFullName(user),
+		CreatedAt: user.CreTimestamp,
+		Role: user.Role.String(),
+		Version: user.Version,
+	}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminUpdateUser(ctx context.Context, req *pb.AdminUser) (resp *emptypb.Empty, err error) {
+
+	resp = &emptypb.Empty{}
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	if admin.Username == req.Id {
+		return nil, status.Errorf(codes.Unauthenticated, "self role change not permitted")
+	}
+
+	var pbRole pb.UserRole
+	role := strings.ToUpper(strings.TrimSpace(req.Role))
+	switch role {
+	case "USER":
+		pbRole = pb.UserRole_USER
+	case "ADMIN":
+		pbRole = pb.UserRole_ADMIN
+	default :
+		return nil, status.Errorf(codes.InvalidArgument, "unknown role '%s'", role)
+	}
+
+	var before, after *pb.UserEntity
+	err = t.UserService.DoWithUser(ctx, req.Id, func(user *pb.UserEntity) error {
+		if user.Version != req.Version {
+			return errors.Wrapf(service.ErrVersionConflict, "user '%s' was changed by another user, current version is %d", req.Id, user.Version)
+		}
+		before = proto.Clone(user).(*pb.UserEntity)
+		user.Role = pbRole
+		after = user
+		return nil
+	})
+	if err == nil {
+		t.logAudit(ctx, admin.Username, "AdminUpdateUser", req.Id, before, after)
+		err = t.logRoleChange(ctx, req.Id, admin.Username, before.Role, pbRole)
+	}
+	if err != nil {
+		err = t.wrapError(err, "AdminUpdateUser", req.Id)
+	}
+
+	return
+}
+
+func (t *implUIGrpcServer) AdminDeleteUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	resp = &emptypb.Empty{}
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	before, _ := t.UserService.GetUser(ctx, req.Id)
+
+	err = t.UserService.RemoveUser(ctx, req.Id)
+	if err != nil {
+		err = t.wrapError(err, "AdminDeleteUser", req.Id)
+	} else {
+		t.logAudit(ctx, admin.Username, "AdminDeleteUser", req.Id, before, nil)
+		err = t.UserService.DropUserContent(context.Background(), req.Id)
+		if err != nil {
+			err = t.wrapError(err, "DropUserContent", req.Id)
+		}
+	}
+
+	return
+}
+
+func (t *implUIGrpcServer) AdminSanitizeReport(ctx context.Context, _ *emptypb.Empty) (*pb.SanitizeReportResponse, error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	resp := new(pb.SanitizeReportResponse)
+	err := t.PageService.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		rendered, err := t.RenderService.RenderPage(page)
+		if err != nil {
+			// malformed content is reported as removed
+			rendered = &api.RenderedPage{Removed: []string{err.Error()}}
+		}
+		if len(rendered.Removed) > 0 {
+			resp.Items = append(resp.Items, &pb.SanitizeReportItem{
+				Name:        page.Name,
+				Title:       page.Title,
+				ContentType: page.ContentType.String(),
+				Removed:     rendered.Removed,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, t.wrapError(err, "AdminSanitizeReport", admin.Username)
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) AdminRun(ctx context.Context, req *pb.Command)  (*pb.CommandResult, error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role ADMIN is required")
+	}
+
+	// role changes are audited by setUserRole, read only commands are not audited
+	switch req.Command {
+	case "add":
+		return t.setUserRole(ctx, admin.Username, req, pb.UserRole_ADMIN)
+	case "remove":
+		return t.setUserRole(ctx, admin.Username, req, pb.UserRole_USER)
+	case "list":
+		var out strings.Builder
+		err := t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
+			if user.Role == pb.UserRole_ADMIN {
+				out.WriteString(fmt.Sprintf("%s, %s, ADMIN\n", user.Email, getFullName(user)))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, t.wrapError(err, "AdminRun", admin.Username)
+		}
+		return &pb.CommandResult{Content: out.String()}, err
+	case "verify-log":
+		return t.verifyLog(ctx, req)
+	case "reindex":
+		cnt, err := t.PageService.RebuildIndex(ctx)
+		if err != nil {
+			return nil, t.wrapError(err, "AdminRun", admin.Username)
+		}
+		t.logAudit(ctx, admin.Username, "AdminRun", req.Command, nil, nil)
+		return &pb.CommandResult{Content: fmt.Sprintf("OK, %d pages indexed", cnt)}, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown command '%s', allowed commands 'add,remove,list,verify-log,reindex'", req.Command)
+	}
+
+}
+
+func (t *implUIGrpcServer) verifyLog(ctx context.Context, req *pb.Command) (*pb.CommandResult, error) {
+	if len(req.Args) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "command needs userId or 'audit' argument")
+	}
+	name := req.Args[0]
+
+	var cnt int
+	var err error
+	if name == "audit" {
+		cnt, err = t.AuditLogService.VerifyActions(ctx)
+	} else {
+		cnt, err = t.SecurityLogService.VerifyEvents(ctx, name)
+	}
+	if errors.Is(err, service.ErrBrokenLogChain) {
+		return &pb.CommandResult{Content: fmt.Sprintf("FAILED, %v", err)}, nil
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "verifyLog", name)
+	}
+
+	return &pb.CommandResult{Content: fmt.Sprintf("OK, %d entries verified", cnt)}, nil
+}
+
+// only real privilege changes are security events
+func (t *implUIGrpcServer) logRoleChange(ctx context.Context, userId, actorId string, previous, role pb.UserRole) error {
+	if previous == role {
+		return nil
+	}
+	return t.logSecurityEvent(ctx, userId, actorId, pb.SecurityEventType_ROLE_CHANGE, pb.SecurityEventOutcome_SUCCESS, map[string]string{
+		"previous_role": previous.String(),
+		"role":          role.String(),
+	})
+}
+
+func (t *implUIGrpcServer) setUserRole(ctx context.Context, actorId string, req *pb.Command, role pb.UserRole) (*pb.CommandResult, error) {
+	if len(req.Args) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "command needs email argument")
+	}
+	email := req.Args[0]
+
+	userId, err := t.UserService.GetUserIdByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *pb.UserEntity
+	err = t.UserService.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+		before = proto.Clone(user).(*pb.UserEntity)
+		user.Role = role
+		after = user
+		return nil
+	})
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user '%s' not found", email)
+	}
+	if err == nil {
+		t.logAudit(ctx, actorId, "SetUserRole", userId, before, after)
+		err = t.logRoleChange(ctx, userId, actorId, before.Role, role)
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "setUserRole", email)
+	}
+
+	return &pb.CommandResult{Content: "OK"}, nil
+}
+
+func (t *implUIGrpcServer) AdminAuditLog(ctx context.Context, req *pb.AdminAuditLogRequest) (resp *pb.AdminAuditLogResponse, err error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminAuditLog", admin.Username)
+		}
+
+	}()
+
+	actorId := strings.TrimSpace(req.ActorId)
+
+	var log []*pb.AuditLogEntity
+	err = t.AuditLogService.EnumActions(ctx, req.FromTime, req.ToTime, func(event *pb.AuditLogEntity) bool {
+		if actorId == "" || event.ActorId == actorId {
+			log = append(log, event)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(log)
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	limit := int(req.Limit)
+
+	if offset >= total {
+		return &pb.AdminAuditLogResponse{Total: int32(total)}, nil
+	}
+	var items []*pb.AuditLogItem
+
+	for j := total - 1 - offset; j >= 0 && limit > 0; j-- {
+
+		var changes []*pb.AuditChange
+		for _, change := range log[j].Changes {
+			changes = append(changes, &pb.AuditChange{
+				Field:  change.Field,
+				Before: change.Before,
+				After:  change.After,
+			})
+		}
+
+		items = append(items, &pb.AuditLogItem{
+			Position:  int32(j + 1),
+			EventTime: log[j].EventTime,
+			ActorId:   log[j].ActorId,
+			Action:    log[j].Action,
+			Target:    log[j].Target,
+			Before:    log[j].Before,
+			After:     log[j].After,
+			Changes:   changes,
+			RemoteIp:  log[j].RemoteIp,
+			UserAgent: log[j].UserAgent,
+		})
+
+		limit--
+	}
+
+	return &pb.AdminAuditLogResponse{
+		Total:   int32(total),
+		Items:   items,
+	}, nil
+}
+
+func (t *implUIGrpcServer) AdminExportPages(ctx context.Context, _ *emptypb.Empty) (*pb.PageFiles, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	return t.exportPages(ctx, "AdminExportPages", user.Username)
+}
+
+func (t *implUIGrpcServer) AdminImportPages(ctx context.Context, req *pb.PageImport) (*pb.PageImportResult, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	return t.importPages(ctx, req, "AdminImportPages", user.Username)
+}
+
+// 'pages export' command
+func (t *implUIGrpcServer) ExportPages(ctx context.Context, _ *emptypb.Empty) (*pb.PageFiles, error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role ADMIN is required")
+	}
+
+	return t.exportPages(ctx, "ExportPages", admin.Username)
+}
+
+// 'pages import' command
+func (t *implUIGrpcServer) ImportPages(ctx context.Context, req *pb.PageImport) (*pb.PageImportResult, error) {
+
+	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !admin.Roles["ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role ADMIN is required")
+	}
+
+	return t.importPages(ctx, req, "ImportPages", admin.Username)
+}
+
+func (t *implUIGrpcServer) exportPages(ctx context.Context, method, username string) (*pb.PageFiles, error) {
+
+	files, err := t.PageSyncService.ExportPages(ctx)
+	if err != nil {
+		return nil, t.wrapError(err, method, username)
+	}
+
+	return &pb.PageFiles{Files: files}, nil
+}
+
+func (t *implUIGrpcServer) importPages(ctx context.Context, req *pb.PageImport, method, username string) (*pb.PageImportResult, error) {
+
+	changes, err := t.PageSyncService.ImportPages(ctx, req.Files, req.DryRun, username)
+	if err != nil {
+		return nil, t.wrapError(err, method, username)
+	}
+
+	if !req.DryRun && len(changes) > 0 {
+		t.logAudit(ctx, username, method, fmt.Sprintf("%d files, %d changes", len(req.Files), len(changes)), nil, nil)
+	}
+	return &pb.PageImportResult{Changes: changes, DryRun: req.DryRun}, nil
+}
+
+// page layout must exist, empty one is page.default-layout
+func (t *implUIGrpcServer) checkLayout(ctx context.Context, name string) error {
+	if utils.NormalizeIdentityField(name) == "" {
+		return nil
+	}
+	_, err := t.FragmentService.GetLayout(ctx, name)
+	if err == service.ErrLayoutNotFound {
+		return errors.Errorf("nowrap: layout '%s' is not found", name)
+	}
+	return err
+}
+
+func (t *implUIGrpcServer) AdminFragmentScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminFragmentScanResponse, err error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminFragmentScan", user.Username)
+		}
+
+	}()
+
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	limit := int(req.Limit)
+
+	var total int
+	var items []*pb.FragmentItem
+	err = t.FragmentService.EnumFragments(ctx, func(fragment *pb.FragmentEntity) bool {
+		if offset > 0 {
+			offset--
+		} else if limit > 0 {
+			items = append(items, &pb.FragmentItem{
+				Position:    int32(total + 1),
+				Name:        fragment.Name,
+				Title:       fragment.Title,
+				ContentType: fragment.ContentType.String(),
+				UpdatedAt:   fragment.UpdTimestamp,
+				UpdatedBy:   fragment.UpdatedBy,
+			})
+			limit--
+		}
+		total++
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AdminFragmentScanResponse{Items: items, Total: int32(total)}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminGetFragment(ctx context.Context, req *pb.FragmentName) (*pb.AdminFragment, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	fragment, err := t.FragmentService.GetFragment(ctx, req.Name)
+	if err == service.ErrFragmentNotFound {
+		return nil, status.Errorf(codes.NotFound, "fragment not found")
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "AdminGetFragment", user.Username)
+	}
+
+	return &pb.AdminFragment{
+		Name:         fragment.Name,
+		Title:        fragment.Title,
+		Content:      fragment.Content,
+		ContentType:  fragment.ContentType.String(),
+		Version:      fragment.Version,
+		CreatedAt:    fragment.CreTimestamp,
+		UpdatedAt:    fragment.UpdTimestamp,
+		CreatedBy:    fragment.CreatedBy,
+		UpdatedBy:    fragment.UpdatedBy,
+		ContentTypes: t.RenderService.ContentTypes(),
+	}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminCreateFragment(ctx context.Context, req *pb.AdminFragment) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	err := t.FragmentService.CreateFragment(ctx, req, user.Username)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminCreateFragment", user.Username)
+	}
+
+	after, _ := t.FragmentService.GetFragment(ctx, req.Name)
+	t.logAudit(ctx, user.Username, "AdminCreateFragment", req.Name, nil, after)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminUpdateFragment(ctx context.Context, req *pb.AdminFragment) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	before, _ := t.FragmentService.GetFragment(ctx, req.Name)
+
+	err := t.FragmentService.UpdateFragment(ctx, req, user.Username)
+	if err == service.ErrFragmentNotFound {
+		return nil, status.Errorf(codes.NotFound, "fragment not found")
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "AdminUpdateFragment", user.Username)
+	}
+
+	after, _ := t.FragmentService.GetFragment(ctx, req.Name)
+	t.logAudit(ctx, user.Username, "AdminUpdateFragment", req.Name, before, after)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminDeleteFragment(ctx context.Context, req *pb.FragmentName) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	before, _ := t.FragmentService.GetFragment(ctx, req.Name)
+
+	err := t.FragmentService.RemoveFragment(ctx, req.Name)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminDeleteFragment", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminDeleteFragment", req.Name, before, nil)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminLayoutScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminLayoutScanResponse, err error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminLayoutScan", user.Username)
+		}
+
+	}()
+
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	limit := int(req.Limit)
+
+	var total int
+	var items []*pb.LayoutItem
+	err = t.FragmentService.EnumLayouts(ctx, func(layout *pb.LayoutEntity) bool {
+		if offset > 0 {
+			offset--
+		} else if limit > 0 {
+			items = append(items, &pb.LayoutItem{
+				Position:  int32(total + 1),
+				Name:      layout.Name,
+				Title:     layout.Title,
+				Header:    layout.Header,
+				Footer:    layout.Footer,
+				UpdatedAt: layout.UpdTimestamp,
+				UpdatedBy: layout.UpdatedBy,
+			})
+			limit--
+		}
+		total++
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AdminLayoutScanResponse{Items: items, Total: int32(total)}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminSaveLayout(ctx context.Context, req *pb.LayoutItem) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	before, _ := t.FragmentService.GetLayout(ctx, req.Name)
+
+	layout := &pb.LayoutEntity{
+		Name:   req.Name,
+		Title:  req.Title,
+		Header: req.Header,
+		Footer: req.Footer,
+	}
+
+	err := t.FragmentService.SaveLayout(ctx, layout, user.Username)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminSaveLayout", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminSaveLayout", layout.Name, before, layout)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminDeleteLayout(ctx context.Context, req *pb.FragmentName) (*emptypb.Empty, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	before, _ := t.FragmentService.GetLayout(ctx, req.Name)
+
+	err := t.FragmentService.RemoveLayout(ctx, req.Name)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminDeleteLayout", user.Username)
+	}
+
+	t.logAudit(ctx, user.Username, "AdminDeleteLayout", req.Name, before, nil)
+	return &emptypb.Empty{}, nil
+
+}
+
+func (t *implUIGrpcServer) AdminTrafficStats(ctx context.Context, req *pb.TrafficStatsRequest) (*pb.TrafficStats, error) {
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok || !user.Roles["WEB_ADMIN"] {
+		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	}
+
+	toTime := req.ToTime
+	if toTime == 0 {
+		toTime = time.Now().Unix()
+	}
+	fromTime := req.FromTime
+	if fromTime == 0 {
+		fromTime = toTime - 7*24*3600
+	}
+	top := int(req.Top)
+	if top <= 0 {
+		top = 10
+	}
+
+	stats, err := t.TrafficService.TrafficStats(ctx, fromTime, toTime, req.Hourly, top)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminTrafficStats", user.Username)
+	}
+
+	return stats, nil
 }
@@ -22,10 +22,13 @@ import (
 	"github.com/codeallergy/sprint"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"math/rand"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,6 +39,10 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 		return nil, status.Errorf(codes.NotFound, "user not found")
 	}
 	if err == service.ErrUserInvalidPassword {
+		err = t.logSecurityEvent(ctx, entity.UserId, "", pb.SecurityEventType_LOGIN, pb.SecurityEventOutcome_FAILURE, map[string]string{"reason": "invalid password"})
+		if err != nil {
+			t.Log.Error("Login", zap.String("userId", entity.UserId), zap.Error(err))
+		}
 		return nil, status.Errorf(codes.Unauthenticated, "invalid password")
 	}
 
@@ -60,6 +67,7 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
 		Username:  entity.UserId,
 		Roles:     roles,
+		Context:   sessionContext(entity),
 		ExpiresAt: time.Now().Add(time.Minute * time.Duration(t.AccessTokenMinutes)).Unix(),
 	})
 
@@ -69,6 +77,7 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 
 	refreshToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
 		Username:  entity.UserId,
+		Context:   sessionContext(entity),
 		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.RefreshTokenHours)).Unix(),
 	})
 
@@ -77,11 +86,28 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 	}
 
 	remoteIP, userAgent := getCallerInfo(ctx)
-	err = t.SecurityLogService.LogEvent(ctx, entity.UserId, "Login", remoteIP, userAgent)
+	newDevice, err := t.isNewDevice(ctx, entity.UserId, remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	var details map[string]string
+	if newDevice {
+		details = map[string]string{"new_device": "true"}
+	}
+
+	err = t.logSecurityEvent(ctx, entity.UserId, "", pb.SecurityEventType_LOGIN, pb.SecurityEventOutcome_SUCCESS, details)
 	if err != nil {
 		return nil, err
 	}
 
+	if newDevice {
+		err = t.sendNewSignInMail(entity, remoteIP, userAgent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	t.loginCnt.Inc()
 
 	return &pb.LoginResponse{
@@ -95,6 +121,10 @@ func (t *implUIGrpcServer) Logout(ctx context.Context, _ *emptypb.Empty) (*empty
 	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
 	if ok {
 		t.AuthorizationMiddleware.InvalidateToken(user.Token)
+		err := t.logSecurityEvent(ctx, user.Username, "", pb.SecurityEventType_LOGOUT, pb.SecurityEventOutcome_SUCCESS, nil)
+		if err != nil {
+			t.Log.Error("Logout", zap.String("userId", user.Username), zap.Error(err))
+		}
 	}
 
 	return &emptypb.Empty{}, nil
@@ -103,7 +133,7 @@ func (t *implUIGrpcServer) Logout(ctx context.Context, _ *emptypb.Empty) (*empty
 func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (resp *pb.LoginResponse, err error) {
 	
 	user, err := t.AuthorizationMiddleware.ParseToken(req.RefreshToken)
-	if err != nil {
+	if err != nil || user.Roles[notMeRole] {
 		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
 	}
 
@@ -119,6 +149,13 @@ func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest)
 	if err == service.ErrUserNotFound {
 		return nil, status.Errorf(codes.NotFound, "user not found")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSessionValid(user, info) {
+		return nil, status.Errorf(codes.Unauthenticated, "session revoked")
+	}
 
 	roles := make(map[string]bool)
 	roles["WEB_USER"] = true
@@ -129,6 +166,7 @@ func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest)
 	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
 		Username:  user.Username,
 		Roles:     roles,
+		Context:   sessionContext(info),
 		ExpiresAt: time.Now().Add(time.Minute * time.Duration(t.AccessTokenMinutes)).Unix(),
 	})
 
@@ -138,6 +176,7 @@ func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest)
 
 	refreshToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
 		Username:  user.Username,
+		Context:   sessionContext(info),
 		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.RefreshTokenHours)).Unix(),
 	})
 
@@ -166,6 +205,10 @@ func (t *implUIGrpcServer) User(ctx context.Context, _ *emptypb.Empty) (*pb.User
 		return nil, t.wrapError(err, "User", user.Username)
 	}
 
+	if !isSessionValid(user, info) {
+		return nil, status.Errorf(codes.Unauthenticated, "session revoked")
+	}
+
 	u := &pb.User{
 		UserId:     info.UserId,
 		FirstName:  info.FirstName,
@@ -256,15 +299,15 @@ func (t *implUIGrpcServer) Register(ctx context.Context, req *pb.RegisterRequest
 		go t.MailService.SendMail(&mail, time.Minute, false)
 	}
 
-	remoteIP, userAgent := getCallerInfo(ctx)
-	err = t.SecurityLogService.LogEvent(ctx, entity.UserId, "Registration", remoteIP, userAgent)
+	// the user is registered already, so the failed log does not fail the call
+	err = t.logSecurityEvent(ctx, entity.UserId, "", pb.SecurityEventType_REGISTRATION, pb.SecurityEventOutcome_SUCCESS, nil)
 	if err != nil {
-		return nil, err
+		t.Log.Error("Register", zap.String("userId", entity.UserId), zap.Error(err))
 	}
 
 	t.registerCnt.Inc()
 
-	return &emptypb.Empty{}, err
+	return &emptypb.Empty{}, nil
 }
 
 func (t *implUIGrpcServer) Restore(ctx context.Context, req *pb.RestoreRequest) (*emptypb.Empty, error) {
@@ -331,10 +374,19 @@ func (t *implUIGrpcServer) doRestore(ctx context.Context, req *pb.RestoreRequest
 		RemoteIp:     remoteIP,
 		CreTimestamp: time.Now().Unix(),
 	}, 60 * 20)
+	if err != nil {
+		return nil, err
+	}
+
+	// the code is sent already, the failed call would be retried and send another one
+	err = t.logSecurityEvent(ctx, entity.UserId, "", pb.SecurityEventType_RECOVER_REQUEST, pb.SecurityEventOutcome_SUCCESS, nil)
+	if err != nil {
+		t.Log.Error("Restore", zap.String("userId", entity.UserId), zap.Error(err))
+	}
 
 	t.restoreCnt.Inc()
 
-	return &emptypb.Empty{}, err
+	return &emptypb.Empty{}, nil
 }
 
 func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (resp *emptypb.Empty, err error) {
@@ -343,6 +395,12 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 
 	err = t.UserService.ValidateRecoverCode(ctx, req.Email, req.Code)
 	if err == service.ErrInvalidRecoverCode {
+		if userId, e := t.UserService.GetUserIdByEmail(ctx, req.Email); e == nil {
+			e = t.logSecurityEvent(ctx, userId, "", pb.SecurityEventType_RESET_PASSWORD, pb.SecurityEventOutcome_FAILURE, map[string]string{"reason": "invalid recover code"})
+			if e != nil {
+				t.Log.Error("Reset", zap.String("userId", userId), zap.Error(e))
+			}
+		}
 		return nil, status.Errorf(codes.InvalidArgument, "wrong recovery code")
 	}
 
@@ -367,11 +425,12 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 	support := t.Properties.GetString("mail.support", "support@localhost")
 
 	subject := fmt.Sprintf("Password reset for %s.", req.Email)
-	remoteIP, userAgent := getCallerInfo(ctx)
+	remoteIP, _ := getCallerInfo(ctx)
 
-	err = t.SecurityLogService.LogEvent(ctx, userId, "ResetPassword", remoteIP, userAgent)
+	// the password is changed already
+	err = t.logSecurityEvent(ctx, userId, "", pb.SecurityEventType_RESET_PASSWORD, pb.SecurityEventOutcome_SUCCESS, nil)
 	if err != nil {
-		return nil, err
+		t.Log.Error("Reset", zap.String("userId", userId), zap.Error(err))
 	}
 
 	mail := sprint.Mail{
@@ -410,8 +469,34 @@ func (t *implUIGrpcServer) SecurityLog(ctx context.Context, req *pb.SecurityLogR
 
 	}()
 
+	var eventType pb.SecurityEventType
+	filterType := strings.TrimSpace(req.EventType) != ""
+	if filterType {
+		value, ok := pb.SecurityEventType_value[strings.ToUpper(strings.TrimSpace(req.EventType))]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event type '%s'", req.EventType)
+		}
+		eventType = pb.SecurityEventType(value)
+	}
+
+	var outcome pb.SecurityEventOutcome
+	filterOutcome := strings.TrimSpace(req.Outcome) != ""
+	if filterOutcome {
+		value, ok := pb.SecurityEventOutcome_value[strings.ToUpper(strings.TrimSpace(req.Outcome))]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown outcome '%s'", req.Outcome)
+		}
+		outcome = pb.SecurityEventOutcome(value)
+	}
+
 	var log []*pb.SecurityLogEntity
 	err = t.SecurityLogService.EnumEvents(ctx, user.Username, func(event *pb.SecurityLogEntity) bool {
+		if filterType && event.EventType != eventType {
+			return true
+		}
+		if filterOutcome && event.Outcome != outcome {
+			return true
+		}
 		log = append(log, event)
 		return true
 	})
@@ -439,6 +524,10 @@ func (t *implUIGrpcServer) SecurityLog(ctx context.Context, req *pb.SecurityLogR
 			EventTime: log[j].EventTime,
 			RemoteIp:  log[j].RemoteIp,
 			UserAgent: log[j].UserAgent,
+			EventType: log[j].EventType.String(),
+			Outcome:   log[j].Outcome.String(),
+			ActorId:   log[j].ActorId,
+			Details:   log[j].Details,
 		})
 
 		limit--
@@ -448,4 +537,170 @@ func (t *implUIGrpcServer) SecurityLog(ctx context.Context, req *pb.SecurityLogR
 		Total:   int32(total),
 		Items:   items,
 	}, nil
-}
\ No newline at end of file
+}
+
+const (
+	notMeRole = "NOT_ME"
+	sessionContextKey = "session"
+)
+
+func sessionContext(user *pb.UserEntity) map[string]string {
+	return map[string]string{
+		sessionContextKey: strconv.FormatInt(user.SessionEpoch, 10),
+	}
+}
+
+// tokens issued before session epochs were introduced belong to the epoch zero
+func isSessionValid(token *sprint.AuthorizedUser, user *pb.UserEntity) bool {
+	var epoch int64
+	if value, ok := token.Context[sessionContextKey]; ok {
+		var err error
+		if epoch, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return false
+		}
+	}
+	return epoch == user.SessionEpoch
+}
+
+// Authenticates every RPC of the services, called by the auth interceptor of the grpc server instead of the middleware.
+// Web tokens of revoked sessions and removed users are ignored, so such calls are made as guests.
+func (t *implUIGrpcServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+
+	authCtx, err := t.AuthorizationMiddleware.Authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := t.AuthorizationMiddleware.GetUser(authCtx)
+	if !ok || !isWebToken(token) {
+		// guests and tokens of the control client
+		return authCtx, nil
+	}
+
+	user, err := t.UserService.GetUser(ctx, token.Username)
+	if err == nil && isSessionValid(token, user) {
+		return authCtx, nil
+	}
+	if err != nil && err != service.ErrUserNotFound {
+		return nil, t.wrapError(err, fullMethodName, token.Username)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Delete("authorization")
+	return t.AuthorizationMiddleware.Authenticate(metadata.NewIncomingContext(ctx, md))
+}
+
+// access and refresh tokens issued by Login and Refresh
+func isWebToken(token *sprint.AuthorizedUser) bool {
+	if _, ok := token.Context[sessionContextKey]; ok {
+		return true
+	}
+	return token.Roles["WEB_USER"] || token.Roles[notMeRole]
+}
+
+// device is known if there was a successful login or registration from the same ip and user agent
+func (t *implUIGrpcServer) isNewDevice(ctx context.Context, userId, remoteIP, userAgent string) (bool, error) {
+
+	if remoteIP == "" {
+		// direct grpc call, not through the gateway
+		return false, nil
+	}
+
+	known := false
+	err := t.SecurityLogService.EnumEvents(ctx, userId, func(event *pb.SecurityLogEntity) bool {
+		if event.Outcome != pb.SecurityEventOutcome_SUCCESS {
+			return true
+		}
+		if event.EventType != pb.SecurityEventType_LOGIN && event.EventType != pb.SecurityEventType_REGISTRATION {
+			return true
+		}
+		if event.RemoteIp == remoteIP && event.UserAgent == userAgent {
+			known = true
+			return false
+		}
+		return true
+	})
+
+	return !known, err
+}
+
+func (t *implUIGrpcServer) sendNewSignInMail(user *pb.UserEntity, remoteIP, userAgent string) error {
+
+	notMeToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username:  user.UserId,
+		Roles:     map[string]bool{notMeRole: true},
+		Context:   sessionContext(user),
+		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.NotMeTokenHours)).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	mail := sprint.Mail{
+		Sender:       t.Properties.GetString("mail.sender", "noreply@localhost"),
+		Recipients:   []string{user.Email},
+		Subject:      fmt.Sprintf("New sign-in to %s.", t.WebappName),
+		TextTemplate: "resources:mail/new_signin_text.tmpl",
+		HtmlTemplate: "resources:mail/new_signin_html.tmpl",
+		Data:         map[string]interface{} {
+			"FirstName": user.FirstName,
+			"RemoteIP": remoteIP,
+			"UserAgent": userAgent,
+			"Time": time.Now().String(),
+			"NotMeURL": fmt.Sprintf("%s/auth/not_me?token=%s", strings.TrimSuffix(t.WebappURL, "/"), url.QueryEscape(notMeToken)),
+			"Project": t.WebappName,
+		},
+	}
+
+	go t.MailService.SendMail(&mail, time.Minute, false)
+	return nil
+}
+
+func (t *implUIGrpcServer) NotMe(ctx context.Context, req *pb.NotMeRequest) (resp *pb.NotMeResponse, err error) {
+
+	token, err := t.AuthorizationMiddleware.ParseToken(req.Token)
+	if err != nil || !token.Roles[notMeRole] {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "NotMe", token.Username)
+		}
+
+	}()
+
+	entity, err := t.UserService.GetUser(ctx, token.Username)
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the link works only once, sessions were already revoked otherwise
+	if !isSessionValid(token, entity) {
+		return nil, status.Errorf(codes.Unauthenticated, "link already used")
+	}
+
+	_, err = t.UserService.RevokeSessions(ctx, entity.UserId)
+	if err != nil {
+		return nil, err
+	}
+	t.AuthorizationMiddleware.InvalidateToken(req.Token)
+
+	// sessions are revoked already and the link does not work again
+	err = t.logSecurityEvent(ctx, entity.UserId, "", pb.SecurityEventType_SESSIONS_REVOKED, pb.SecurityEventOutcome_SUCCESS, map[string]string{"reason": "not me"})
+	if err != nil {
+		t.Log.Error("NotMe", zap.String("userId", entity.UserId), zap.Error(err))
+	}
+
+	_, err = t.doRestore(ctx, &pb.RestoreRequest{Email: entity.Email})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.NotMeResponse{Email: entity.Email}, nil
+}
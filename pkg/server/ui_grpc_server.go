@@ -16,15 +16,17 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
-	"github.com/gomarkdown/markdown"
 	"github.com/pkg/errors"
 	"github.com/codeallergy/store"
 	"github.com/codeallergy/glue"
 	"github.com/codeallergy/template/pkg/api"
 	"github.com/codeallergy/template/pkg/pb"
 	"github.com/codeallergy/template/pkg/service"
+	"github.com/codeallergy/template/pkg/utils"
 	"github.com/codeallergy/sprint"
 	"github.com/codeallergy/sprintframework/pkg/util"
 	"go.uber.org/atomic"
@@ -32,20 +34,29 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"html"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
 
+// page size of the public search
+const maxSearchResults = 20
+
 
 type implUIGrpcServer struct {
 	pb.UnimplementedAuthServiceServer
 	pb.UnimplementedSiteServiceServer
 	pb.UnimplementedAdminServiceServer
 
-	WebappName string `value:"webapp.name,default=Light-Template"`
+	WebappName   string `value:"webapp.name,default=Light-Template"`
+	WebappURL    string `value:"webapp.url,default=https://localhost:8443"`
+	NotFoundPage string `value:"page.not-found,default=not-found"`
 
 	GrpcServer       *grpc.Server   `inject`
 	UIGatewayServer  *http.Server   `inject:"bean=control-gateway-server"`
@@ -60,7 +71,13 @@ type implUIGrpcServer struct {
 
 	UserService           api.UserService   `inject`
 	SecurityLogService    api.SecurityLogService  `inject`
+	AuditLogService       api.AuditLogService  `inject`
 	PageService           api.PageService   `inject`
+	RenderService         api.RenderService  `inject`
+	MediaService          api.MediaService   `inject`
+	PageSyncService       api.PageSyncService  `inject`
+	FragmentService       api.FragmentService  `inject`
+	TrafficService        api.TrafficService  `inject`
 	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
 
 	Log             *zap.Logger          `inject`
@@ -71,6 +88,7 @@ type implUIGrpcServer struct {
 
 	AccessTokenMinutes   int   `value:"auth.access-token-minutes,default=20"`
 	RefreshTokenHours    int   `value:"auth.refresh-token-hours,default=24"`
+	NotMeTokenHours      int   `value:"auth.not-me-token-hours,default=72"`
 }
 
 func UIGrpcServer() api.GRPCServer {
@@ -91,6 +109,9 @@ func (t *implUIGrpcServer) PostConstruct() (err error) {
 		return err
 	}
 
+	// gateway passes etag as grpc metadata, browsers need http headers to revalidate
+	t.UIGatewayServer.Handler = conditionalGet(t.UIGatewayServer.Handler, "/api/")
+
 	// interceptors do not work yet
 	//pb.RegisterAuthServiceHandlerServer(context.Background(), api, t)
 
@@ -121,17 +142,51 @@ func (t *implUIGrpcServer) GetStats(cb func(name, value string) bool) error {
 	cb("register.cnt", strconv.FormatInt(t.registerCnt.Load(), 10))
 	cb("restore.cnt", strconv.FormatInt(t.restoreCnt.Load(), 10))
 
+	hits, misses := t.RenderService.CacheStats()
+	cb("page.render-cache.hits", strconv.FormatInt(hits, 10))
+	cb("page.render-cache.misses", strconv.FormatInt(misses, 10))
+	if hits + misses > 0 {
+		cb("page.render-cache.hit-ratio", strconv.FormatFloat(float64(hits) / float64(hits + misses), 'f', 3, 64))
+	}
+
 	return nil
 }
 
-func (t *implUIGrpcServer) Page(ctx context.Context, req *pb.PageName) (*pb.PageContent, error) {
+func (t *implUIGrpcServer) Page(ctx context.Context, req *pb.PageName) (resp *pb.PageContent, err error) {
 
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	admin := ok && user.Roles["WEB_ADMIN"]
+
+	now := time.Now().Unix()
 	page, err := t.PageService.GetPage(ctx, req.Name)
+	public := err == nil && service.IsPagePublic(page, now)
+	if err == nil && !public && !admin {
+		// hidden pages look like missing ones, admins see the preview
+		err = service.ErrPageNotFound
+	}
 	if err == service.ErrPageNotFound {
-		return &pb.PageContent{
-			Title:   "Page Not Found",
-			Content: fmt.Sprintf("Oops, requested page '%s' is not found.", req.Name),
-		}, nil
+		if redirect, _ := t.PageService.ResolveRedirect(ctx, req.Name); redirect != nil {
+			// the gateway answers 301 or 302 with the location of the new page
+			code := http.StatusMovedPermanently
+			if redirect.Temporary {
+				code = http.StatusFound
+			}
+			location := "/api/page/" + redirect.To
+			if req.Locale != "" {
+				location += "?locale=" + url.QueryEscape(req.Locale)
+			}
+			grpc.SetHeader(ctx, metadata.Pairs("location", location, "redirect-status", strconv.Itoa(code)))
+			return &pb.PageContent{Title: "Page Moved", Redirect: redirect.To, Temporary: redirect.Temporary}, nil
+		}
+		return nil, t.notFound(ctx, req.Name, req.Locale, now)
+	}
+
+	// translations follow the visibility of the default page
+	if err == nil && !service.CanViewPage(page, t.viewer(ctx)) {
+		if !ok {
+			return nil, status.Errorf(codes.Unauthenticated, "sign in to view page '%s'", req.Name)
+		}
+		return nil, status.Errorf(codes.PermissionDenied, "page '%s' is restricted", req.Name)
 	}
 
 	defer func() {
@@ -148,16 +203,258 @@ func (t *implUIGrpcServer) Page(ctx context.Context, req *pb.PageName) (*pb.Page
 		return nil, err
 	}
 
-	var content string
-	if page.ContentType == pb.ContentType_MARKDOWN {
-		content = string(markdown.ToHTML([]byte(page.Content), nil, nil))
-	} else {
-		content = page.Content
+	defaultPage := page
+	page, err = t.localizePage(ctx, page, req.Locale, admin, now)
+	if err != nil {
+		return nil, err
+	}
+	public = public && service.IsPagePublic(page, now)
+
+	locales, err := t.visibleLocales(ctx, defaultPage, admin, now)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := t.RenderService.RenderPage(page)
+	if err != nil {
+		return nil, err
+	}
+
+	// translations share the layout of the default page
+	rendered, err = t.FragmentService.ComposePage(ctx, defaultPage.Layout, rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	// greeting of the signed in user must not be shared
+	personal := false
+	if len(rendered.Variables) > 0 {
+		var values map[string]string
+		values, personal = t.pageVariables(ctx, rendered.Variables)
+		rendered = t.RenderService.Expand(rendered, values)
 	}
 
-	return &pb.PageContent{Title: page.Title, Content: content }, nil
+	resp = &pb.PageContent{
+		Title:        page.Title,
+		Content:      rendered.Content,
+		Description:  page.Description,
+		Keywords:     page.Keywords,
+		OgTitle:      page.OgTitle,
+		OgImage:      absoluteURL(t.WebappURL, page.OgImage),
+		CanonicalUrl: absoluteURL(t.WebappURL, page.CanonicalUrl),
+		Noindex:      page.Noindex,
+		Locale:       page.Locale,
+		Locales:      locales,
+		Tags:         page.Tags,
+	}
+	if defaultPage.Category != "" {
+		resp.FeedUrl = feedURL(t.WebappURL, defaultPage.Category)
+	}
+	if resp.Locale == "" {
+		resp.Locale = t.PageService.DefaultLocale()
+	}
+	if resp.OgTitle == "" {
+		resp.OgTitle = page.Title
+	}
+	if resp.CanonicalUrl == "" {
+		resp.CanonicalUrl = localizedPageURL(t.WebappURL, page)
+	}
+
+	// admin preview of hidden pages is not cached by browsers, variables like the year change without the page,
+	// restricted pages are validated on each request
+	if public && !admin && !personal && defaultPage.Visibility == pb.PageVisibility_PUBLIC {
+		etag, err := contentETag(resp)
+		if err != nil {
+			return nil, err
+		}
+		md := metadata.Pairs("etag", etag)
+		if len(rendered.Variables) == 0 {
+			md.Set("last-modified", time.Unix(rendered.LastModified, 0).UTC().Format(http.TimeFormat))
+		}
+		grpc.SetHeader(ctx, md)
+	}
+	if admin {
+		resp.ContentTypes = t.RenderService.ContentTypes()
+	}
+
+	// translations are counted as the page, admins are not counted
+	if public && !admin {
+		referrer := req.Referrer
+		if referrer == "" {
+			referrer = getReferer(ctx)
+		}
+		remoteIP, userAgent := getCallerInfo(ctx)
+		t.TrafficService.RecordView(ctx, defaultPage.Name, referrer, remoteIP, userAgent, time.Now())
+	}
+
+	return resp, nil
+}
+
+// etag of the whole response, so locales, tags and the feed of the page are revalidated as well as the content
+func contentETag(content *pb.PageContent) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(hash[:16])), nil
 }
 
+// codes.NotFound carrying the rendered page.not-found page in the details, the gateway answers 404 with the status
+// and details in the body, the built-in message is used if the page does not exist or is hidden
+func (t *implUIGrpcServer) notFound(ctx context.Context, name, locale string, now int64) error {
+
+	content := &pb.PageContent{
+		Title:   "Page Not Found",
+		Content: fmt.Sprintf("Oops, requested page '%s' is not found.", html.EscapeString(name)),
+		Noindex: true,
+	}
+
+	page, err := t.PageService.GetPage(ctx, t.NotFoundPage)
+	if err == nil && service.IsPagePublic(page, now) && service.CanViewPage(page, nil) {
+		layout := page.Layout
+		page, err = t.localizePage(ctx, page, locale, false, now)
+		var rendered *api.RenderedPage
+		if err == nil {
+			rendered, err = t.RenderService.RenderPage(page)
+		}
+		if err == nil {
+			rendered, err = t.FragmentService.ComposePage(ctx, layout, rendered)
+		}
+		if err == nil {
+			values, _ := t.pageVariables(ctx, rendered.Variables)
+			content.Title = page.Title
+			content.Content = t.RenderService.Expand(rendered, values).Content
+			content.Locale = page.Locale
+		}
+	}
+	if err != nil && err != service.ErrPageNotFound {
+		t.Log.Error("NotFoundPage", zap.String("page", t.NotFoundPage), zap.Error(err))
+	}
+	if content.Locale == "" {
+		content.Locale = t.PageService.DefaultLocale()
+	}
+
+	st, err := status.New(codes.NotFound, fmt.Sprintf("page '%s' is not found", name)).WithDetails(content)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "page '%s' is not found", name)
+	}
+	return st.Err()
+}
+
+// variables available in the page content as {{.Name}}
+var pageVariableNames = []string{"FirstName", "Project", "Url", "Year"}
+
+// values of the used variables, the user is loaded only for pages having the first name,
+// returns true if the values depend on the signed in user
+func (t *implUIGrpcServer) pageVariables(ctx context.Context, names []string) (map[string]string, bool) {
+
+	values := make(map[string]string)
+	personal := false
+	for _, name := range names {
+		switch name {
+		case "Project":
+			values[name] = t.WebappName
+		case "Url":
+			values[name] = t.WebappURL
+		case "Year":
+			values[name] = strconv.Itoa(time.Now().UTC().Year())
+		case "FirstName":
+			values[name] = ""
+			if user, ok := t.AuthorizationMiddleware.GetUser(ctx); ok {
+				personal = true
+				if entity, err := t.UserService.GetUser(ctx, user.Username); err == nil {
+					values[name] = entity.FirstName
+				}
+			}
+		}
+	}
+	return values, personal
+}
+
+// first visible translation in the chain of the requested locale or Accept-Language, the default page otherwise
+func (t *implUIGrpcServer) localizePage(ctx context.Context, page *pb.PageEntity, locale string, admin bool, now int64) (*pb.PageEntity, error) {
+
+	requested := []string{locale}
+	if locale == "" {
+		requested = utils.ParseAcceptLanguage(getAcceptLanguage(ctx))
+	}
+
+	for _, locale := range t.PageService.LocaleChain(requested) {
+		translation, err := t.PageService.GetPageLocale(ctx, page.Name, locale)
+		if err == service.ErrPageNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if admin || service.IsPagePublic(translation, now) {
+			return translation, nil
+		}
+	}
+
+	return page, nil
+}
+
+// default locale goes first, hidden translations are visible only to admins
+func (t *implUIGrpcServer) visibleLocales(ctx context.Context, page *pb.PageEntity, admin bool, now int64) ([]string, error) {
+
+	translations, err := t.PageService.PageLocales(ctx, page.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	locales := []string{t.PageService.DefaultLocale()}
+	for _, locale := range translations {
+		if !admin {
+			translation, err := t.PageService.GetPageLocale(ctx, page.Name, locale)
+			if err == service.ErrPageNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !service.IsPagePublic(translation, now) {
+				continue
+			}
+		}
+		locales = append(locales, locale)
+	}
+	return locales, nil
+}
+
+func (t *implUIGrpcServer) SearchPages(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+
+	offset := int(req.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	total, items, err := t.PageService.SearchPages(ctx, req.Query, offset, limit, time.Now().Unix(), t.viewer(ctx))
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("SearchPages", zap.String("errorId", id), zap.String("query", req.Query), zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "internal error %s", id)
+	}
+
+	return &pb.SearchResponse{Total: int32(total), Items: items}, nil
+}
+
+func (t *implUIGrpcServer) Menu(ctx context.Context, _ *emptypb.Empty) (*pb.MenuResponse, error) {
+
+	items, err := t.PageService.Menu(ctx, time.Now().Unix(), t.viewer(ctx))
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("Menu", zap.String("errorId", id), zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "internal error %s", id)
+	}
+
+	return &pb.MenuResponse{Items: items}, nil
+}
 
 func (t *implUIGrpcServer) UserDelete(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
 
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"os"
+	"testing"
+)
+
+// every caller is a guest
+type guestMiddleware struct {
+	sprint.AuthorizationMiddleware
+}
+
+func (t guestMiddleware) GetUser(ctx context.Context) (*sprint.AuthorizedUser, bool) {
+	return nil, false
+}
+
+func TestPageETag(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+	renderService := service.RenderService()
+	fragmentService := service.FragmentService()
+	trafficService := service.TrafficService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore,
+		service.HtmlSanitizer(), renderService,
+		service.MarkdownRenderer(),
+		pageService, fragmentService, trafficService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	server := &implUIGrpcServer{
+		WebappURL:               "https://example.com",
+		AuthorizationMiddleware: guestMiddleware{},
+		PageService:             pageService,
+		RenderService:           renderService,
+		FragmentService:         fragmentService,
+		TrafficService:          trafficService,
+		Log:                     log,
+	}
+
+	// the gateway turns the etag header to Grpc-Metadata-Etag
+	pageETag := func() string {
+		var stream runtime.ServerTransportStream
+		_, err := server.Page(grpc.NewContextWithServerTransportStream(context.Background(), &stream), &pb.PageName{Name: "about"})
+		require.NoError(t, err)
+		etag := stream.Header().Get("etag")
+		require.Equal(t, 1, len(etag))
+		return etag[0]
+	}
+
+	bg := context.Background()
+
+	err = pageService.CreatePage(bg, &pb.AdminPage{Name: "about", Title: "About", Content: "Hello", ContentType: "MARKDOWN", Status: "PUBLISHED"}, "admin")
+	require.NoError(t, err)
+
+	etag := pageETag()
+	require.Equal(t, etag, pageETag())
+
+	// tags are the part of the response, the content stays the same
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "about", Title: "About", Content: "Hello", ContentType: "MARKDOWN", Status: "PUBLISHED", Tags: []string{"news"}, Version: 1}, "admin")
+	require.NoError(t, err)
+	require.NotEqual(t, etag, pageETag())
+
+}
@@ -18,11 +18,14 @@ package server
 import (
 	"context"
 	"github.com/pkg/errors"
+	"github.com/codeallergy/sprint"
 	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"strings"
 )
 
@@ -64,6 +67,72 @@ func getUserAgent(md metadata.MD) string {
 	return headers[0]
 }
 
+// Accept-Language header is forwarded by the gateway
+func getAcceptLanguage(ctx context.Context) string {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	headers := md["grpcgateway-accept-language"]
+	if len(headers) == 0 {
+		return ""
+	}
+
+	return headers[0]
+}
+
+// Referer header is forwarded by the gateway, it is the webapp page for calls of the webapp
+func getReferer(ctx context.Context) string {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	headers := md["grpcgateway-referer"]
+	if len(headers) == 0 {
+		return ""
+	}
+
+	return headers[0]
+}
+
+func (t *implUIGrpcServer) logSecurityEvent(ctx context.Context, userId, actorId string, eventType pb.SecurityEventType, outcome pb.SecurityEventOutcome, details map[string]string) error {
+	remoteIP, userAgent := getCallerInfo(ctx)
+	return t.SecurityLogService.LogEvent(ctx, userId, &pb.SecurityLogEntity{
+		EventType: eventType,
+		Outcome:   outcome,
+		ActorId:   actorId,
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+		Details:   details,
+	})
+}
+
+func (t *implUIGrpcServer) logAudit(ctx context.Context, actorId, action, target string, before, after proto.Message) {
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err := t.AuditLogService.LogAction(ctx, &pb.AuditLogEntity{
+		ActorId:   actorId,
+		Action:    action,
+		Target:    target,
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+	}, before, after)
+	if err != nil {
+		t.Log.Error("AuditLog", zap.String("actorId", actorId), zap.String("action", action), zap.String("target", target), zap.Error(err))
+	}
+}
+
+// signed in user or nil for guests
+func (t *implUIGrpcServer) viewer(ctx context.Context) *sprint.AuthorizedUser {
+	if user, ok := t.AuthorizationMiddleware.GetUser(ctx); ok {
+		return user
+	}
+	return nil
+}
+
 func getFullName(user *pb.UserEntity) string {
 	var out strings.Builder
 	if user.FirstName != "" {
@@ -101,6 +170,9 @@ func (t *implUIGrpcServer) wrapError(err error, method, username string) error {
 	if _, ok := status.FromError(err); ok {
 		return err
 	}
+	if errors.Is(err, service.ErrVersionConflict) {
+		return status.Error(codes.Aborted, err.Error())
+	}
 	issue := err.Error()
 	if strings.HasPrefix(issue, "nowrap:") {
 		issue = strings.TrimSpace(strings.TrimPrefix(issue, "nowrap:"))
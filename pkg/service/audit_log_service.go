@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"time"
+)
+
+type implAuditLogService struct {
+	Log            *zap.Logger          `inject`
+	HostStorage    store.DataStore      `inject:"bean=host-storage"`
+	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+
+	LogTtl   int   `value:"audit-log.ttl,default=63072000"`  // two years ttl
+}
+
+// never stored in snapshots and changes
+var auditRedactedFields = map[string]bool{
+	"password_hash": true,
+}
+
+func AuditLogService() api.AuditLogService {
+	return &implAuditLogService{}
+}
+
+func (t *implAuditLogService) LogAction(ctx context.Context, event *pb.AuditLogEntity, before, after proto.Message) (err error) {
+
+	if event.Action == "" {
+		return errors.New("action is empty")
+	}
+
+	// typed nil pointers are not valid snapshots
+	if before != nil && !before.ProtoReflect().IsValid() {
+		before = nil
+	}
+	if after != nil && !after.ProtoReflect().IsValid() {
+		after = nil
+	}
+
+	if before != nil {
+		if event.Before, err = auditSnapshot(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if event.After, err = auditSnapshot(after); err != nil {
+			return err
+		}
+	}
+	event.Changes = auditChanges(before, after)
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	last, lastTime, err := t.lastAction(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := time.Now()
+	if !current.After(lastTime) {
+		// keep the chain in the key order even if clock goes back
+		current = lastTime.Add(time.Millisecond)
+	}
+tryAgain:
+	utc := current.UTC()
+
+	var has bool
+	if has, err = t.hasAction(ctx, utc); err != nil {
+		return err
+	} else if has {
+		current = current.Add(time.Millisecond)
+		goto tryAgain
+	}
+
+	event.EventTime = current.Unix()
+	event.PrevHash = last.Hash
+	event.Hash = nil
+
+	event.Hash, err = chainHash(event)
+	if err != nil {
+		return err
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("audit-log:%s", utc.Format(DDMMYYYYhhmmss)).WithTtl(t.LogTtl).Proto(event)
+	if err != nil {
+		return err
+	}
+
+	// head expires with the last entry, otherwise it points to the removed entry
+	err = t.HostStorage.Set(ctx).ByKey("audit-log-head").WithTtl(t.LogTtl).String(hex.EncodeToString(event.Hash))
+	return
+}
+
+func (t *implAuditLogService) lastAction(ctx context.Context) (last *pb.AuditLogEntity, lastTime time.Time, err error) {
+	last = new(pb.AuditLogEntity)
+	err = t.HostStorage.Enumerate(ctx).ByPrefix("audit-log:").
+		Seek("audit-log:\xff").
+		Reverse().
+		WithBatchSize(1).
+		DoProto(func() proto.Message {
+			return new(pb.AuditLogEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.AuditLogEntity); ok {
+				last = v
+				lastTime, err = parseLogKeyTime(entry.Key)
+			}
+			return false
+		})
+	return
+}
+
+func (t *implAuditLogService) hasAction(ctx context.Context, utc time.Time) (bool, error) {
+	event := new(pb.AuditLogEntity)
+	err := t.HostStorage.Get(ctx).ByKey("audit-log:%s", utc.Format(DDMMYYYYhhmmss)).ToProto(event)
+	if err != nil {
+		return false, err
+	}
+	return event.Action != "", nil
+}
+
+func (t *implAuditLogService) EnumActions(ctx context.Context, fromTime, toTime int64, cb func(item *pb.AuditLogEntity) bool) error {
+
+	op := t.HostStorage.Enumerate(ctx).ByPrefix("audit-log:")
+	if fromTime > 0 {
+		op = op.Seek("audit-log:%s", time.Unix(fromTime, 0).UTC().Format(DDMMYYYYhhmmss))
+	}
+
+	return op.WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.AuditLogEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.AuditLogEntity); ok {
+				if toTime > 0 && v.EventTime > toTime {
+					return false
+				}
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implAuditLogService) VerifyActions(ctx context.Context) (int, error) {
+
+	head, err := t.HostStorage.Get(ctx).ByKey("audit-log-head").ToString()
+	if err != nil {
+		return 0, err
+	}
+
+	var verifier chainVerifier
+	err = t.EnumActions(ctx, 0, 0, func(item *pb.AuditLogEntity) bool {
+		withoutHash := proto.Clone(item).(*pb.AuditLogEntity)
+		withoutHash.Hash = nil
+		return verifier.next(item.EventTime, item.Hash, item.PrevHash, withoutHash)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return verifier.finish(head)
+}
+
+func auditSnapshot(msg proto.Message) (string, error) {
+	msg = proto.Clone(msg)
+	m := msg.ProtoReflect()
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if auditRedactedFields[string(fd.Name())] {
+			m.Clear(fd)
+		}
+		return true
+	})
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// compares top level scalar fields, nested messages, lists and maps are present only in snapshots
+func auditChanges(before, after proto.Message) []*pb.AuditFieldChange {
+
+	var desc protoreflect.MessageDescriptor
+	var b, a protoreflect.Message
+	if before != nil {
+		b = before.ProtoReflect()
+		desc = b.Descriptor()
+	}
+	if after != nil {
+		a = after.ProtoReflect()
+		desc = a.Descriptor()
+	}
+	if desc == nil || (b != nil && a != nil && b.Descriptor().FullName() != a.Descriptor().FullName()) {
+		return nil
+	}
+
+	var changes []*pb.AuditFieldChange
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if auditRedactedFields[string(fd.Name())] || fd.IsList() || fd.IsMap() || fd.Message() != nil {
+			continue
+		}
+		if !(b != nil && b.Has(fd)) && !(a != nil && a.Has(fd)) {
+			continue
+		}
+		var beforeValue, afterValue string
+		if b != nil {
+			beforeValue = auditValue(fd, b.Get(fd))
+		}
+		if a != nil {
+			afterValue = auditValue(fd, a.Get(fd))
+		}
+		if beforeValue != afterValue {
+			changes = append(changes, &pb.AuditFieldChange{
+				Field:  string(fd.Name()),
+				Before: beforeValue,
+				After:  afterValue,
+			})
+		}
+	}
+	return changes
+}
+
+func auditValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+	case protoreflect.BytesKind:
+		return "<binary>"
+	}
+	return v.String()
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	auditLogService := service.AuditLogService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, auditLogService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyAuditLog(t, auditLogService)
+	verifyAuditLogChain(t, auditLogService, hostStore)
+
+}
+
+func verifyAuditLog(t *testing.T, auditLogService api.AuditLogService) {
+
+	ctx := context.Background()
+
+	before := &pb.UserEntity{
+		UserId:       "u00002",
+		Email:        "test@test.com",
+		PasswordHash: []byte("secret"),
+		Role:         pb.UserRole_USER,
+	}
+	after := &pb.UserEntity{
+		UserId:       "u00002",
+		Email:        "test@test.com",
+		PasswordHash: []byte("secret"),
+		Role:         pb.UserRole_ADMIN,
+	}
+
+	err := auditLogService.LogAction(ctx, &pb.AuditLogEntity{
+		ActorId: "u00001",
+		Action:  "AdminUpdateUser",
+		Target:  "u00002",
+	}, before, after)
+	require.NoError(t, err)
+
+	var nilPage *pb.PageEntity
+	err = auditLogService.LogAction(ctx, &pb.AuditLogEntity{
+		ActorId: "u00003",
+		Action:  "AdminDeletePage",
+		Target:  "about",
+	}, &pb.PageEntity{Name: "about", Title: "About"}, nilPage)
+	require.NoError(t, err)
+
+	var list []*pb.AuditLogEntity
+	err = auditLogService.EnumActions(ctx, 0, 0, func(item *pb.AuditLogEntity) bool {
+		list = append(list, item)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+
+	update := list[0]
+	require.Equal(t, "AdminUpdateUser", update.Action)
+	require.Equal(t, "u00001", update.ActorId)
+	require.Equal(t, 1, len(update.Changes))
+	require.Equal(t, "role", update.Changes[0].Field)
+	require.Equal(t, "USER", update.Changes[0].Before)
+	require.Equal(t, "ADMIN", update.Changes[0].After)
+	require.False(t, strings.Contains(update.Before, "password_hash"))
+	require.False(t, strings.Contains(update.After, "password_hash"))
+
+	remove := list[1]
+	require.Equal(t, "", remove.After)
+	require.Equal(t, 2, len(remove.Changes))
+
+	future := time.Now().Add(time.Hour).Unix()
+	cnt := 0
+	err = auditLogService.EnumActions(ctx, future, 0, func(item *pb.AuditLogEntity) bool {
+		cnt++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	past := time.Now().Add(-time.Hour).Unix()
+	err = auditLogService.EnumActions(ctx, 0, past, func(item *pb.AuditLogEntity) bool {
+		cnt++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	err = auditLogService.LogAction(ctx, &pb.AuditLogEntity{ActorId: "u00001"}, nil, nil)
+	require.Error(t, err)
+
+}
+
+func verifyAuditLogChain(t *testing.T, auditLogService api.AuditLogService, hostStore store.DataStore) {
+
+	ctx := context.Background()
+
+	cnt, err := auditLogService.VerifyActions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, cnt)
+
+	err = auditLogService.LogAction(ctx, &pb.AuditLogEntity{ActorId: "u00001", Action: "AdminRun"}, nil, nil)
+	require.NoError(t, err)
+
+	var keys [][]byte
+	err = hostStore.Enumerate(ctx).ByPrefix("audit-log:").Do(func(entry *store.RawEntry) bool {
+		keys = append(keys, append([]byte(nil), entry.Key...))
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(keys))
+
+	require.NoError(t, hostStore.Remove(ctx).ByRawKey(keys[1]).Do())
+
+	_, err = auditLogService.VerifyActions(ctx)
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+
+}
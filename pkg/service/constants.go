@@ -16,6 +16,8 @@ package service
 
 const (
 	BatchSize = 128
+
+	maxRedirectHops = 10
 )
 
 
@@ -29,6 +29,16 @@ var (
 	ErrInvalidRecoverCode = errors.New("invalid recover code")
 
 	ErrPageNotFound = errors.New("page not found")
+	ErrPageRevisionNotFound = errors.New("page revision not found")
+
+	ErrMediaNotFound = errors.New("media not found")
+
+	ErrFragmentNotFound = errors.New("fragment not found")
+	ErrLayoutNotFound = errors.New("layout not found")
+
+	ErrBrokenLogChain = errors.New("broken log chain")
+
+	ErrVersionConflict = errors.New("version conflict")
 )
 
 
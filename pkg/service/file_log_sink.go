@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"os"
+	"sync"
+)
+
+// writes security events as json lines
+type implFileLogSink struct {
+	Path   string   `value:"security-log.file-sink.path,default="`
+
+	mu     sync.Mutex
+	file   *os.File
+}
+
+func FileLogSink() api.SecurityLogSink {
+	return &implFileLogSink{}
+}
+
+func (t *implFileLogSink) BeanName() string {
+	return "file_log_sink"
+}
+
+func (t *implFileLogSink) PostConstruct() (err error) {
+	if t.Path == "" {
+		return nil
+	}
+	t.file, err = os.OpenFile(t.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return err
+}
+
+func (t *implFileLogSink) Emit(userId string, event *pb.SecurityLogEntity) error {
+	if t.file == nil {
+		return nil
+	}
+
+	data, err := securityEventJSON(userId, event)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err = t.file.Write(append(data, '\n'))
+	return err
+}
+
+func (t *implFileLogSink) Destroy() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file != nil {
+		err := t.file.Close()
+		t.file = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/utils"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// {{include name}} in the source content
+var fragmentIncludeRe = regexp.MustCompile(`\{\{\s*include\s+([a-z0-9_-]+)\s*\}\}`)
+
+// {{include name}} in the rendered content, the paragraph around the standalone include is replaced as well
+var renderedIncludeRe = regexp.MustCompile(`(<p>\s*)?\{\{\s*include\s+([a-z0-9_-]+)\s*\}\}(\s*</p>)?`)
+
+type implFragmentService struct {
+	HostStorage    store.DataStore      `inject:"bean=host-storage"`
+	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+	RenderService  api.RenderService    `inject`
+
+	DefaultLayout  string  `value:"page.default-layout,default="`
+}
+
+func FragmentService() api.FragmentService {
+	return &implFragmentService{}
+}
+
+func (t *implFragmentService) GetFragment(ctx context.Context, name string) (*pb.FragmentEntity, error) {
+
+	name = utils.NormalizeIdentityField(name)
+	if name == "" {
+		return nil, errors.New("fragment name is empty")
+	}
+
+	fragment := new(pb.FragmentEntity)
+	err := t.HostStorage.Get(ctx).ByKey("fragment:%s", name).ToProto(fragment)
+	if err != nil {
+		return nil, err
+	}
+	if fragment.Name == "" {
+		return nil, ErrFragmentNotFound
+	}
+	return fragment, nil
+}
+
+func (t *implFragmentService) CreateFragment(ctx context.Context, req *pb.AdminFragment, authorId string) error {
+	return t.saveFragment(ctx, req, authorId, true)
+}
+
+func (t *implFragmentService) UpdateFragment(ctx context.Context, req *pb.AdminFragment, authorId string) error {
+	return t.saveFragment(ctx, req, authorId, false)
+}
+
+func (t *implFragmentService) saveFragment(ctx context.Context, req *pb.AdminFragment, authorId string, create bool) (err error) {
+
+	req.Name = utils.NormalizeIdentityField(req.Name)
+	if req.Name == "" {
+		return errors.New("nowrap: fragment name is empty")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	current := new(pb.FragmentEntity)
+	err = t.HostStorage.Get(ctx).ByKey("fragment:%s", req.Name).ToProto(current)
+	if err != nil {
+		return
+	}
+
+	if create && current.Name != "" {
+		err = errors.Errorf("nowrap: fragment '%s' already exist", req.Name)
+		return
+	}
+	if !create && current.Name == "" {
+		err = ErrFragmentNotFound
+		return
+	}
+	if !create && current.Version != req.Version {
+		err = errors.Wrapf(ErrVersionConflict, "fragment '%s' was changed by another user, current version is %d", req.Name, current.Version)
+		return
+	}
+
+	contentType, err := t.RenderService.ParseContentType(req.ContentType)
+	if err != nil {
+		err = errors.Errorf("nowrap: invalid content type '%s'", req.ContentType)
+		return
+	}
+
+	if _, _, err = t.RenderService.Render(contentType, req.Content); err != nil {
+		err = errors.Errorf("nowrap: %v", err)
+		return
+	}
+
+	err = t.checkIncludes(ctx, req.Name, req.Content)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	entity := &pb.FragmentEntity{
+		Name:         req.Name,
+		Title:        req.Title,
+		Content:      req.Content,
+		ContentType:  contentType,
+		CreTimestamp: now,
+		UpdTimestamp: now,
+		CreatedBy:    authorId,
+		UpdatedBy:    authorId,
+		Version:      current.Version + 1,
+	}
+	if current.Name != "" {
+		entity.CreTimestamp = current.CreTimestamp
+		entity.CreatedBy = current.CreatedBy
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("fragment:%s", req.Name).Proto(entity)
+	if err != nil {
+		return
+	}
+
+	t.RenderService.Invalidate(fragmentCacheKey(req.Name))
+	return
+}
+
+// names of the included fragments in the order of appearance
+func fragmentIncludes(content string) []string {
+	var names []string
+	for _, match := range fragmentIncludeRe.FindAllStringSubmatch(content, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// walks includes of the saved fragment over the stored ones, missing fragments are skipped
+func (t *implFragmentService) checkIncludes(ctx context.Context, name, content string) error {
+
+	var visit func(content string, stack []string) error
+	visit = func(content string, stack []string) error {
+		for _, include := range fragmentIncludes(content) {
+			for _, seen := range stack {
+				if seen == include {
+					return errors.Errorf("nowrap: recursive include %s", strings.Join(append(stack, include), " -> "))
+				}
+			}
+			fragment, err := t.GetFragment(ctx, include)
+			if err == ErrFragmentNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := visit(fragment.Content, append(stack, include)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return visit(content, []string{name})
+}
+
+func (t *implFragmentService) RemoveFragment(ctx context.Context, name string) (err error) {
+
+	name = utils.NormalizeIdentityField(name)
+	if name == "" {
+		return errors.New("fragment name is empty")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	var usedBy string
+	err = t.EnumLayouts(ctx, func(layout *pb.LayoutEntity) bool {
+		if layout.Header == name || layout.Footer == name {
+			usedBy = layout.Name
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if usedBy != "" {
+		err = errors.Errorf("nowrap: fragment '%s' is used by layout '%s'", name, usedBy)
+		return
+	}
+
+	err = t.HostStorage.Remove(ctx).ByKey("fragment:%s", name).Do()
+	if err != nil {
+		return
+	}
+
+	t.RenderService.Invalidate(fragmentCacheKey(name))
+	return
+}
+
+func (t *implFragmentService) EnumFragments(ctx context.Context, cb func(fragment *pb.FragmentEntity) bool) error {
+
+	return t.HostStorage.Enumerate(ctx).
+		ByPrefix("fragment:").
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.FragmentEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.FragmentEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implFragmentService) GetLayout(ctx context.Context, name string) (*pb.LayoutEntity, error) {
+
+	name = utils.NormalizeIdentityField(name)
+	if name == "" {
+		return nil, errors.New("layout name is empty")
+	}
+
+	layout := new(pb.LayoutEntity)
+	err := t.HostStorage.Get(ctx).ByKey("layout:%s", name).ToProto(layout)
+	if err != nil {
+		return nil, err
+	}
+	if layout.Name == "" {
+		return nil, ErrLayoutNotFound
+	}
+	return layout, nil
+}
+
+func (t *implFragmentService) SaveLayout(ctx context.Context, layout *pb.LayoutEntity, authorId string) (err error) {
+
+	layout.Name = utils.NormalizeIdentityField(layout.Name)
+	if layout.Name == "" {
+		return errors.New("nowrap: layout name is empty")
+	}
+	layout.Header = utils.NormalizeIdentityField(layout.Header)
+	layout.Footer = utils.NormalizeIdentityField(layout.Footer)
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	for _, name := range []string{layout.Header, layout.Footer} {
+		if name == "" {
+			continue
+		}
+		_, err = t.GetFragment(ctx, name)
+		if err == ErrFragmentNotFound {
+			err = errors.Errorf("nowrap: fragment '%s' is not found", name)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	current := new(pb.LayoutEntity)
+	err = t.HostStorage.Get(ctx).ByKey("layout:%s", layout.Name).ToProto(current)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	layout.UpdTimestamp = now
+	layout.UpdatedBy = authorId
+	if current.Name != "" {
+		layout.CreTimestamp = current.CreTimestamp
+		layout.CreatedBy = current.CreatedBy
+	} else {
+		layout.CreTimestamp = now
+		layout.CreatedBy = authorId
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("layout:%s", layout.Name).Proto(layout)
+	return
+}
+
+func (t *implFragmentService) RemoveLayout(ctx context.Context, name string) error {
+
+	name = utils.NormalizeIdentityField(name)
+	if name == "" {
+		return errors.New("layout name is empty")
+	}
+
+	return t.HostStorage.Remove(ctx).ByKey("layout:%s", name).Do()
+}
+
+func (t *implFragmentService) EnumLayouts(ctx context.Context, cb func(layout *pb.LayoutEntity) bool) error {
+
+	return t.HostStorage.Enumerate(ctx).
+		ByPrefix("layout:").
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.LayoutEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.LayoutEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+// state of one ComposePage call
+type composition struct {
+	ctx           context.Context
+	lastModified  int64
+}
+
+func (t *implFragmentService) ComposePage(ctx context.Context, layoutName string, rendered *api.RenderedPage) (*api.RenderedPage, error) {
+
+	layoutName = utils.NormalizeIdentityField(layoutName)
+	if layoutName == "" {
+		layoutName = utils.NormalizeIdentityField(t.DefaultLayout)
+	}
+
+	return t.compose(ctx, layoutName, rendered)
+}
+
+func (t *implFragmentService) ResolveIncludes(ctx context.Context, rendered *api.RenderedPage) (*api.RenderedPage, error) {
+	return t.compose(ctx, "", rendered)
+}
+
+// resolves includes and wraps the content by the layout if the name is not empty
+func (t *implFragmentService) compose(ctx context.Context, layoutName string, rendered *api.RenderedPage) (*api.RenderedPage, error) {
+
+	if layoutName == "" && !strings.Contains(rendered.Content, "{{") {
+		return rendered, nil
+	}
+
+	c := &composition{
+		ctx:          ctx,
+		lastModified: rendered.LastModified,
+	}
+
+	content, err := t.resolve(c, rendered.Content, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if layoutName != "" {
+		layout, err := t.GetLayout(ctx, layoutName)
+		switch err {
+		case nil:
+			if layout.UpdTimestamp > c.lastModified {
+				c.lastModified = layout.UpdTimestamp
+			}
+			header, err := t.include(c, layout.Header, nil)
+			if err != nil {
+				return nil, err
+			}
+			footer, err := t.include(c, layout.Footer, nil)
+			if err != nil {
+				return nil, err
+			}
+			content = header + content + footer
+		case ErrLayoutNotFound:
+			// the page is shown without the layout until it is created
+		default:
+			return nil, err
+		}
+	}
+
+	return &api.RenderedPage{
+		Content:      content,
+		LastModified: c.lastModified,
+		Removed:      rendered.Removed,
+		Variables:    pageVariables(content),
+	}, nil
+}
+
+// replaces includes of the rendered content, stack has names of the fragments being included
+func (t *implFragmentService) resolve(c *composition, content string, stack []string) (string, error) {
+
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+
+	var err error
+	content = renderedIncludeRe.ReplaceAllStringFunc(content, func(match string) string {
+		if err != nil {
+			return match
+		}
+		groups := renderedIncludeRe.FindStringSubmatch(match)
+		var included string
+		included, err = t.include(c, groups[2], stack)
+		if groups[1] != "" && groups[3] != "" {
+			return included
+		}
+		return groups[1] + included + groups[3]
+	})
+	return content, err
+}
+
+// rendered fragment with resolved includes, missing fragment is empty
+func (t *implFragmentService) include(c *composition, name string, stack []string) (string, error) {
+
+	if name == "" {
+		return "", nil
+	}
+
+	for _, seen := range stack {
+		if seen == name {
+			return "", errors.Errorf("recursive include %s", strings.Join(append(stack, name), " -> "))
+		}
+	}
+
+	fragment, err := t.GetFragment(c.ctx, name)
+	if err == ErrFragmentNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rendered, err := t.RenderService.RenderFragment(fragment)
+	if err != nil {
+		return "", err
+	}
+
+	if rendered.LastModified > c.lastModified {
+		c.lastModified = rendered.LastModified
+	}
+
+	return t.resolve(c, rendered.Content, append(stack, name))
+}
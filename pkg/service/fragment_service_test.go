@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestFragmentService(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	renderService := service.RenderService()
+	fragmentService := service.FragmentService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore,
+		service.HtmlSanitizer(), renderService,
+		service.MarkdownRenderer(),
+		service.HtmlRenderer(),
+		fragmentService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyFragments(t, renderService, fragmentService)
+
+}
+
+func verifyFragments(t *testing.T, renderService api.RenderService, fragmentService api.FragmentService) {
+
+	ctx := context.Background()
+
+	err := fragmentService.CreateFragment(ctx, &pb.AdminFragment{Name: "Contacts", Title: "Contacts", Content: "Mail *us*", ContentType: "MARKDOWN"}, "admin")
+	require.NoError(t, err)
+
+	err = fragmentService.CreateFragment(ctx, &pb.AdminFragment{Name: "footer", Content: "<footer>{{include contacts}}</footer>", ContentType: "HTML"}, "admin")
+	require.NoError(t, err)
+
+	err = fragmentService.CreateFragment(ctx, &pb.AdminFragment{Name: "footer", Content: "again", ContentType: "HTML"}, "admin")
+	require.Error(t, err)
+
+	// contacts -> footer -> contacts
+	contacts, err := fragmentService.GetFragment(ctx, "contacts")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), contacts.Version)
+
+	err = fragmentService.UpdateFragment(ctx, &pb.AdminFragment{Name: "contacts", Content: "{{include footer}}", ContentType: "MARKDOWN", Version: 1}, "admin")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "recursive include contacts -> footer -> contacts")
+
+	err = fragmentService.UpdateFragment(ctx, &pb.AdminFragment{Name: "contacts", Content: "{{include contacts}}", ContentType: "MARKDOWN", Version: 1}, "admin")
+	require.Error(t, err)
+
+	page, err := renderService.RenderPage(&pb.PageEntity{Name: "about", Content: "Hello\n\n{{include contacts}}\n\nBye {{include missing}}", Version: 1, UpdTimestamp: 1000})
+	require.NoError(t, err)
+
+	composed, err := fragmentService.ComposePage(ctx, "", page)
+	require.NoError(t, err)
+	require.Equal(t, "<p>Hello</p>\n\n<p>Mail <em>us</em></p>\n\n\n<p>Bye </p>\n", composed.Content)
+	require.Equal(t, contacts.UpdTimestamp, composed.LastModified)
+
+	// page without includes and layout stays as is
+	plain, err := renderService.RenderPage(&pb.PageEntity{Name: "plain", Content: "Plain", Version: 1})
+	require.NoError(t, err)
+	same, err := fragmentService.ComposePage(ctx, "", plain)
+	require.NoError(t, err)
+	require.Equal(t, plain, same)
+
+	err = fragmentService.SaveLayout(ctx, &pb.LayoutEntity{Name: "main", Header: "missing"}, "admin")
+	require.Error(t, err)
+
+	err = fragmentService.SaveLayout(ctx, &pb.LayoutEntity{Name: "main", Title: "Main", Footer: "footer"}, "admin")
+	require.NoError(t, err)
+
+	composed, err = fragmentService.ComposePage(ctx, "main", plain)
+	require.NoError(t, err)
+	require.Equal(t, "<p>Plain</p>\n<footer><p>Mail <em>us</em></p>\n</footer>", composed.Content)
+
+	// composed page follows the included fragments
+	err = fragmentService.UpdateFragment(ctx, &pb.AdminFragment{Name: "contacts", Content: "Call us", ContentType: "MARKDOWN", Version: 1}, "admin")
+	require.NoError(t, err)
+
+	err = fragmentService.UpdateFragment(ctx, &pb.AdminFragment{Name: "contacts", Content: "stale", ContentType: "MARKDOWN", Version: 1}, "admin")
+	require.ErrorIs(t, err, service.ErrVersionConflict)
+
+	composed, err = fragmentService.ComposePage(ctx, "main", plain)
+	require.NoError(t, err)
+	require.Equal(t, "<p>Plain</p>\n<footer><p>Call us</p>\n</footer>", composed.Content)
+
+	err = fragmentService.RemoveFragment(ctx, "footer")
+	require.Error(t, err)
+
+	err = fragmentService.RemoveLayout(ctx, "main")
+	require.NoError(t, err)
+
+	err = fragmentService.RemoveFragment(ctx, "footer")
+	require.NoError(t, err)
+
+	_, err = fragmentService.GetFragment(ctx, "footer")
+	require.Equal(t, service.ErrFragmentNotFound, err)
+
+	_, err = fragmentService.GetLayout(ctx, "main")
+	require.Equal(t, service.ErrLayoutNotFound, err)
+
+	var names []string
+	err = fragmentService.EnumFragments(ctx, func(fragment *pb.FragmentEntity) bool {
+		names = append(names, fragment.Name)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"contacts"}, names)
+
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"golang.org/x/net/html"
+	"io"
+	"strings"
+)
+
+var defaultSanitizerTags = []string{
+	"a", "abbr", "article", "aside", "b", "blockquote", "br", "caption", "cite", "code", "col", "colgroup",
+	"dd", "del", "details", "div", "dl", "dt", "em", "figcaption", "figure", "footer", "h1", "h2", "h3",
+	"h4", "h5", "h6", "header", "hr", "i", "img", "ins", "kbd", "li", "mark", "nav", "ol", "p", "pre",
+	"q", "s", "section", "small", "span", "strong", "sub", "summary", "sup", "table", "tbody", "td",
+	"tfoot", "th", "thead", "tr", "u", "ul",
+}
+
+var defaultSanitizerAttributes = []string{
+	"*.class", "*.id", "*.title", "a.href", "a.rel", "img.src", "img.alt", "img.width", "img.height",
+	"ol.start", "td.colspan", "td.rowspan", "th.colspan", "th.rowspan", "th.scope",
+}
+
+// content of these elements is removed with the element
+var sanitizerDropContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "select": true,
+}
+
+// variables of the site configuration allowed in url attributes, like {{.Url}}/about
+var urlPageVariables = map[string]bool{
+	"Url": true,
+}
+
+// attributes having url values, checked against the allowed schemes
+var sanitizerUrlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true, "action": true, "formaction": true,
+	"poster": true, "background": true, "srcset": true, "xlink:href": true,
+}
+
+type sanitizerPolicy struct {
+	tags       map[string]bool
+	attributes map[string]bool  // tag.attr or *.attr
+}
+
+type implHtmlSanitizer struct {
+	HtmlTags            []string   `value:"sanitizer.html.tags,default="`
+	HtmlAttributes      []string   `value:"sanitizer.html.attributes,default="`
+	MarkdownTags        []string   `value:"sanitizer.markdown.tags,default="`
+	MarkdownAttributes  []string   `value:"sanitizer.markdown.attributes,default="`
+	UrlSchemes          []string   `value:"sanitizer.url-schemes,default=http;https;mailto"`
+
+	policies  map[pb.ContentType]*sanitizerPolicy
+	schemes   map[string]bool
+}
+
+func HtmlSanitizer() api.HtmlSanitizer {
+	return &implHtmlSanitizer{}
+}
+
+func (t *implHtmlSanitizer) PostConstruct() error {
+
+	t.policies = map[pb.ContentType]*sanitizerPolicy{
+		pb.ContentType_HTML:     newSanitizerPolicy(t.HtmlTags, t.HtmlAttributes),
+		pb.ContentType_MARKDOWN: newSanitizerPolicy(t.MarkdownTags, t.MarkdownAttributes),
+	}
+
+	t.schemes = make(map[string]bool)
+	for _, scheme := range t.UrlSchemes {
+		t.schemes[strings.ToLower(scheme)] = true
+	}
+	return nil
+}
+
+// empty lists mean the default policy
+func newSanitizerPolicy(tags, attributes []string) *sanitizerPolicy {
+	if len(tags) == 0 {
+		tags = defaultSanitizerTags
+	}
+	if len(attributes) == 0 {
+		attributes = defaultSanitizerAttributes
+	}
+	p := &sanitizerPolicy{
+		tags:       make(map[string]bool),
+		attributes: make(map[string]bool),
+	}
+	for _, tag := range tags {
+		p.tags[strings.ToLower(tag)] = true
+	}
+	for _, attr := range attributes {
+		p.attributes[strings.ToLower(attr)] = true
+	}
+	return p
+}
+
+func (t *implHtmlSanitizer) Sanitize(contentType pb.ContentType, src string) (string, []string) {
+
+	policy, ok := t.policies[contentType]
+	if !ok {
+		policy = t.policies[pb.ContentType_HTML]
+	}
+
+	var out strings.Builder
+	var removed []string
+	seen := make(map[string]bool)
+	remove := func(what string) {
+		if !seen[what] {
+			seen[what] = true
+			removed = append(removed, what)
+		}
+	}
+
+	// name and depth of the element dropped with the content
+	var skipTag string
+	var skipDepth int
+
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				remove("invalid html")
+			}
+			break
+		}
+
+		tok := z.Token()
+
+		if skipDepth > 0 {
+			switch {
+			case tt == html.StartTagToken && tok.Data == skipTag:
+				skipDepth++
+			case tt == html.EndTagToken && tok.Data == skipTag:
+				skipDepth--
+			}
+			continue
+		}
+
+		switch tt {
+
+		case html.TextToken:
+			out.WriteString(html.EscapeString(tok.Data))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if sanitizerDropContent[tok.Data] {
+				remove("<" + tok.Data + ">")
+				if tt == html.StartTagToken {
+					skipTag, skipDepth = tok.Data, 1
+				}
+				continue
+			}
+			if !policy.tags[tok.Data] {
+				remove("<" + tok.Data + ">")
+				continue
+			}
+
+			out.WriteByte('<')
+			out.WriteString(tok.Data)
+			for _, attr := range tok.Attr {
+				if !t.allowAttribute(policy, tok.Data, attr) {
+					remove(tok.Data + "." + attr.Key)
+					continue
+				}
+				out.WriteByte(' ')
+				out.WriteString(attr.Key)
+				out.WriteString(`="`)
+				out.WriteString(html.EscapeString(attr.Val))
+				out.WriteByte('"')
+			}
+			if tt == html.SelfClosingTagToken {
+				out.WriteString("/>")
+			} else {
+				out.WriteByte('>')
+			}
+
+		case html.EndTagToken:
+			if policy.tags[tok.Data] {
+				out.WriteString("</")
+				out.WriteString(tok.Data)
+				out.WriteByte('>')
+			}
+
+		case html.CommentToken:
+			remove("comment")
+
+		case html.DoctypeToken:
+			remove("doctype")
+		}
+	}
+
+	return out.String(), removed
+}
+
+func (t *implHtmlSanitizer) allowAttribute(policy *sanitizerPolicy, tag string, attr html.Attribute) bool {
+
+	// event handlers are never allowed
+	if attr.Namespace != "" || strings.HasPrefix(attr.Key, "on") {
+		return false
+	}
+	if !policy.attributes[tag + "." + attr.Key] && !policy.attributes["*." + attr.Key] {
+		return false
+	}
+	if sanitizerUrlAttributes[attr.Key] {
+		// page variables are expanded after the sanitizer, values of users could make any scheme
+		val := pageVariableRe.ReplaceAllStringFunc(attr.Val, func(match string) string {
+			if urlPageVariables[pageVariableRe.FindStringSubmatch(match)[1]] {
+				return ""
+			}
+			return match
+		})
+		if strings.Contains(val, "{{") {
+			return false
+		}
+		return t.allowUrl(val)
+	}
+	return true
+}
+
+// relative urls are allowed, absolute ones need the allowed scheme
+func (t *implHtmlSanitizer) allowUrl(url string) bool {
+
+	// browsers ignore whitespace and control characters in the scheme
+	url = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, url)
+
+	i := strings.IndexAny(url, ":/?#")
+	if i == -1 || url[i] != ':' {
+		return true
+	}
+	return t.schemes[strings.ToLower(url[:i])]
+}
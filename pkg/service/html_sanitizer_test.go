@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHtmlSanitizer(t *testing.T) {
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"sanitizer.markdown.tags": "p;em;strong;a",
+		"sanitizer.markdown.attributes": "a.href",
+	}}
+
+	sanitizer := service.HtmlSanitizer()
+
+	ctx, err := glue.New(properties, sanitizer)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	out, removed := sanitizer.Sanitize(pb.ContentType_HTML,
+		`<p class="intro" onclick="steal()">Hello <b>world</b></p><script>alert("x")</script><!-- note -->`)
+	require.Equal(t, `<p class="intro">Hello <b>world</b></p>`, out)
+	require.Equal(t, []string{"p.onclick", "<script>", "comment"}, removed)
+
+	out, removed = sanitizer.Sanitize(pb.ContentType_HTML,
+		`<a href="java&#x09;script:alert(1)">x</a><a href="/about">y</a><a href="https://example.com" target="_blank">z</a>`)
+	require.Equal(t, `<a>x</a><a href="/about">y</a><a href="https://example.com">z</a>`, out)
+	require.Equal(t, []string{"a.href", "a.target"}, removed)
+
+	// only the site url variable is allowed in urls
+	out, removed = sanitizer.Sanitize(pb.ContentType_HTML,
+		`<a href="{{.FirstName}}">x</a><a href="/users/{{ .FirstName }}" title="{{.FirstName}}">y</a><a href="{{.Url}}/about">z</a><a href="javascript:{{.Url}}">w</a>`)
+	require.Equal(t, `<a>x</a><a title="{{.FirstName}}">y</a><a href="{{.Url}}/about">z</a><a>w</a>`, out)
+	require.Equal(t, []string{"a.href"}, removed)
+
+	// nested dropped elements and text escaping
+	out, removed = sanitizer.Sanitize(pb.ContentType_HTML,
+		`<object data="x"><object></object>inside</object>1 &lt; 2<svg><circle/></svg>`)
+	require.Equal(t, `1 &lt; 2`, out)
+	require.Equal(t, []string{"<object>", "<svg>", "<circle>"}, removed)
+
+	// markdown has own policy from properties
+	out, removed = sanitizer.Sanitize(pb.ContentType_MARKDOWN,
+		`<h1 id="title">Title</h1><p><em>a</em> <a href="/x" title="t">link</a></p>`)
+	require.Equal(t, `Title<p><em>a</em> <a href="/x">link</a></p>`, out)
+	require.Equal(t, []string{"<h1>", "a.title"}, removed)
+
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// entry should have empty hash field, prev_hash is the part of the content
+func chainHash(entry proto.Message) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// Walks log entries in the key order and finds the first broken link.
+// Entries written before the hash chain are skipped until the first hashed one,
+// the oldest hashed entry is an anchor, because older entries could be expired by TTL.
+type chainVerifier struct {
+	position int
+	chained  bool
+	prevHash []byte
+	err      error
+}
+
+// returns false if the chain is broken, the reason is in err field
+func (t *chainVerifier) next(eventTime int64, hash, prevHash []byte, withoutHash proto.Message) bool {
+
+	t.position++
+	at := time.Unix(eventTime, 0).UTC().Format(DDMMYYYYhhmmss)
+
+	if len(hash) == 0 {
+		if t.chained {
+			t.err = errors.Wrapf(ErrBrokenLogChain, "entry %d at %s has no hash", t.position, at)
+			return false
+		}
+		return true
+	}
+
+	if t.chained && !bytes.Equal(prevHash, t.prevHash) {
+		t.err = errors.Wrapf(ErrBrokenLogChain, "entry %d at %s does not link to the previous entry", t.position, at)
+		return false
+	}
+
+	expected, err := chainHash(withoutHash)
+	if err != nil {
+		t.err = err
+		return false
+	}
+
+	if !bytes.Equal(expected, hash) {
+		t.err = errors.Wrapf(ErrBrokenLogChain, "entry %d at %s content was modified", t.position, at)
+		return false
+	}
+
+	t.chained = true
+	t.prevHash = hash
+	return true
+}
+
+// head is the hex hash of the last written entry, it expires together with the entries,
+// so the head without entries means the log was removed
+func (t *chainVerifier) finish(head string) (int, error) {
+	if t.err != nil {
+		return t.position, t.err
+	}
+	if head != "" && head != hex.EncodeToString(t.prevHash) {
+		return t.position, errors.Wrapf(ErrBrokenLogChain, "chain of %d entries does not end with the recorded head, last entries were removed", t.position)
+	}
+	return t.position, nil
+}
+
+// log keys end with the event time in DDMMYYYYhhmmss format
+func parseLogKeyTime(key []byte) (time.Time, error) {
+	if len(key) < len(DDMMYYYYhhmmss) {
+		return time.Time{}, errors.Errorf("invalid log key '%s'", string(key))
+	}
+	return time.ParseInLocation(DDMMYYYYhhmmss, string(key[len(key)-len(DDMMYYYYhhmmss):]), time.UTC)
+}
@@ -0,0 +1,365 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// hex chars of the checksum used as media id
+	mediaIdLength = 24
+
+	// larger images are stored without the thumbnail
+	maxThumbnailSourcePixels = 40 * 1000 * 1000
+)
+
+type implMediaService struct {
+	Log            *zap.Logger          `inject`
+	HostStorage    store.DataStore      `inject:"bean=host-storage"`
+	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+
+	// files are kept in host-storage if the directory is empty
+	Directory      string    `value:"media.directory,default="`
+	// grpc limits the message by 4mb, base64 makes the upload larger by one third
+	MaxSize        int       `value:"media.max-size,default=3145728"`
+	ContentTypes   []string  `value:"media.content-types,default=image/png;image/jpeg;image/gif;image/webp;application/pdf"`
+	ThumbnailSize  int       `value:"media.thumbnail-size,default=256"`
+
+	contentTypes   map[string]bool
+}
+
+func MediaService() api.MediaService {
+	return &implMediaService{}
+}
+
+func (t *implMediaService) PostConstruct() error {
+
+	t.contentTypes = make(map[string]bool)
+	for _, ct := range t.ContentTypes {
+		t.contentTypes[strings.ToLower(strings.TrimSpace(ct))] = true
+	}
+
+	if t.Directory != "" {
+		if err := os.MkdirAll(t.Directory, 0700); err != nil {
+			return errors.Wrapf(err, "create media directory '%s'", t.Directory)
+		}
+	}
+	return nil
+}
+
+func (t *implMediaService) SaveMedia(ctx context.Context, fileName string, data []byte, createdBy string) (media *pb.MediaEntity, err error) {
+
+	fileName = filepath.Base(strings.ReplaceAll(strings.TrimSpace(fileName), "\\", "/"))
+	if fileName == "." || fileName == "/" {
+		fileName = ""
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("nowrap: file is empty")
+	}
+	if len(data) > t.MaxSize {
+		return nil, errors.Errorf("nowrap: file size %d exceeds the limit of %d bytes", len(data), t.MaxSize)
+	}
+
+	// the declared type and the file extension are not trusted
+	contentType := http.DetectContentType(data)
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	if !t.contentTypes[contentType] {
+		return nil, errors.Errorf("nowrap: content type '%s' is not allowed", contentType)
+	}
+
+	hash := sha256.Sum256(data)
+	checksum := hex.EncodeToString(hash[:])
+
+	media = &pb.MediaEntity{
+		Id:           checksum[:mediaIdLength],
+		FileName:     fileName,
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		Checksum:     checksum,
+		CreTimestamp: time.Now().Unix(),
+		CreatedBy:    createdBy,
+	}
+
+	var thumbnail []byte
+	if strings.HasPrefix(contentType, "image/") {
+		thumbnail = t.makeThumbnail(media, data)
+		media.Thumbnail = thumbnail != nil
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	existing := new(pb.MediaEntity)
+	err = t.HostStorage.Get(ctx).ByKey("media:%s", media.Id).ToProto(existing)
+	if err != nil {
+		return
+	}
+	if existing.Id != "" {
+		// already uploaded
+		return existing, nil
+	}
+
+	err = t.writeFile(ctx, "media-data", media.Id, data)
+	if err != nil {
+		return
+	}
+	if thumbnail != nil {
+		err = t.writeFile(ctx, "media-thumb", media.Id, thumbnail)
+		if err != nil {
+			return
+		}
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("media:%s", media.Id).Proto(media)
+	return
+}
+
+func (t *implMediaService) GetMedia(ctx context.Context, id string) (*pb.MediaEntity, error) {
+
+	if !isMediaId(id) {
+		return nil, ErrMediaNotFound
+	}
+
+	media := new(pb.MediaEntity)
+	err := t.HostStorage.Get(ctx).ByKey("media:%s", id).ToProto(media)
+	if err != nil {
+		return nil, err
+	}
+	if media.Id == "" {
+		return nil, ErrMediaNotFound
+	}
+	return media, nil
+}
+
+func (t *implMediaService) ReadMedia(ctx context.Context, media *pb.MediaEntity, thumbnail bool) ([]byte, error) {
+
+	prefix := "media-data"
+	if thumbnail {
+		if !media.Thumbnail {
+			return nil, ErrMediaNotFound
+		}
+		prefix = "media-thumb"
+	}
+
+	if t.Directory != "" {
+		data, err := os.ReadFile(t.mediaPath(prefix, media.Id))
+		if os.IsNotExist(err) {
+			return nil, ErrMediaNotFound
+		}
+		return data, err
+	}
+
+	data, err := t.HostStorage.Get(ctx).ByKey("%s:%s", prefix, media.Id).ToBinary()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrMediaNotFound
+	}
+	return data, nil
+}
+
+func (t *implMediaService) RemoveMedia(ctx context.Context, id string) (err error) {
+
+	if !isMediaId(id) {
+		return ErrMediaNotFound
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	media := new(pb.MediaEntity)
+	err = t.HostStorage.Get(ctx).ByKey("media:%s", id).ToProto(media)
+	if err != nil {
+		return
+	}
+	if media.Id == "" {
+		err = ErrMediaNotFound
+		return
+	}
+
+	for _, prefix := range []string{"media-data", "media-thumb"} {
+		if t.Directory != "" {
+			if err = os.Remove(t.mediaPath(prefix, id)); err != nil && !os.IsNotExist(err) {
+				return
+			}
+			err = nil
+		} else if err = t.HostStorage.Remove(ctx).ByKey("%s:%s", prefix, id).Do(); err != nil {
+			return
+		}
+	}
+
+	err = t.HostStorage.Remove(ctx).ByKey("media:%s", id).Do()
+	return
+}
+
+func (t *implMediaService) EnumMedia(ctx context.Context, cb func(media *pb.MediaEntity) bool) error {
+
+	return t.HostStorage.Enumerate(ctx).
+		ByPrefix("media:").
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.MediaEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.MediaEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implMediaService) writeFile(ctx context.Context, prefix, id string, data []byte) error {
+	if t.Directory == "" {
+		return t.HostStorage.Set(ctx).ByKey("%s:%s", prefix, id).Binary(data)
+	}
+	return os.WriteFile(t.mediaPath(prefix, id), data, 0600)
+}
+
+func (t *implMediaService) mediaPath(prefix, id string) string {
+	if prefix == "media-thumb" {
+		return filepath.Join(t.Directory, id + ".thumb")
+	}
+	return filepath.Join(t.Directory, id)
+}
+
+// id is used in file names, so only the checksum prefix is accepted
+func isMediaId(id string) bool {
+	if len(id) != mediaIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// sets dimensions of the image and returns the thumbnail, nil if the format is not supported by the decoder
+func (t *implMediaService) makeThumbnail(media *pb.MediaEntity, data []byte) []byte {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// webp has no decoder in the standard library
+		return nil
+	}
+	media.Width = int32(config.Width)
+	media.Height = int32(config.Height)
+
+	if config.Width * config.Height > maxThumbnailSourcePixels || t.ThumbnailSize <= 0 {
+		return nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Log.Warn("MediaThumbnail", zap.String("fileName", media.FileName), zap.Error(err))
+		return nil
+	}
+
+	thumb := scaleImage(img, t.ThumbnailSize)
+
+	var out bytes.Buffer
+	if media.ContentType == "image/jpeg" {
+		err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		// keeps transparency
+		err = png.Encode(&out, thumb)
+	}
+	if err != nil {
+		t.Log.Warn("MediaThumbnail", zap.String("fileName", media.FileName), zap.Error(err))
+		return nil
+	}
+	return out.Bytes()
+}
+
+// fits the image into the square keeping the aspect ratio, each pixel is the average of the source box
+func scaleImage(src image.Image, size int) image.Image {
+
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, sh * size / sw
+		} else {
+			dw, dh = sw * size / sh, size
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy * sh / dh, (dy + 1) * sh / dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx * sw / dw, (dx + 1) * sw / dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sr, sg, sb, sa := src.At(b.Min.X + x, b.Min.Y + y).RGBA()
+					r, g, bl, a = r + uint64(sr), g + uint64(sg), bl + uint64(sb), a + uint64(sa)
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMediaService(t *testing.T) {
+
+	mediaDir, err := os.MkdirTemp(os.TempDir(), "media-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(mediaDir)
+
+	// files in host-storage and in the directory
+	for _, directory := range []string{"", filepath.Join(mediaDir, "files")} {
+		verifyMediaStorage(t, directory)
+	}
+}
+
+func verifyMediaStorage(t *testing.T, directory string) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"media.directory": directory,
+		"media.max-size":  100000,
+	}}
+
+	mediaService := service.MediaService()
+
+	ctx, err := glue.New(log, hostStore, properties, mediaService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyMedia(t, mediaService)
+}
+
+func verifyMedia(t *testing.T, mediaService api.MediaService) {
+
+	ctx := context.Background()
+
+	img := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 300; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	data := buf.Bytes()
+
+	// extension does not matter, the type is sniffed
+	media, err := mediaService.SaveMedia(ctx, "C:\\photos\\picture.jpg", data, "admin")
+	require.NoError(t, err)
+	require.Equal(t, "picture.jpg", media.FileName)
+	require.Equal(t, "image/png", media.ContentType)
+	require.Equal(t, int64(len(data)), media.Size)
+	require.Len(t, media.Checksum, 64)
+	require.Equal(t, media.Checksum[:24], media.Id)
+	require.Equal(t, int32(600), media.Width)
+	require.Equal(t, int32(300), media.Height)
+	require.True(t, media.Thumbnail)
+
+	// same content keeps the first upload
+	again, err := mediaService.SaveMedia(ctx, "copy.png", data, "other")
+	require.NoError(t, err)
+	require.Equal(t, media.Id, again.Id)
+	require.Equal(t, "picture.jpg", again.FileName)
+
+	content, err := mediaService.ReadMedia(ctx, media, false)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	thumbnail, err := mediaService.ReadMedia(ctx, media, true)
+	require.NoError(t, err)
+	thumb, err := png.Decode(bytes.NewReader(thumbnail))
+	require.NoError(t, err)
+	require.Equal(t, 256, thumb.Bounds().Dx())
+	require.Equal(t, 128, thumb.Bounds().Dy())
+
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\n")
+	doc, err := mediaService.SaveMedia(ctx, "doc.pdf", pdf, "admin")
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", doc.ContentType)
+	require.False(t, doc.Thumbnail)
+
+	_, err = mediaService.ReadMedia(ctx, doc, true)
+	require.Equal(t, service.ErrMediaNotFound, err)
+
+	_, err = mediaService.SaveMedia(ctx, "page.png", []byte("<html><script>alert(1)</script></html>"), "admin")
+	require.EqualError(t, err, "nowrap: content type 'text/html' is not allowed")
+
+	_, err = mediaService.SaveMedia(ctx, "big.pdf", append(pdf, make([]byte, 100000)...), "admin")
+	require.Error(t, err)
+
+	_, err = mediaService.SaveMedia(ctx, "empty.png", nil, "admin")
+	require.Error(t, err)
+
+	var ids []string
+	err = mediaService.EnumMedia(ctx, func(media *pb.MediaEntity) bool {
+		ids = append(ids, media.Id)
+		return true
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{media.Id, doc.Id}, ids)
+
+	// ids are used in file names
+	_, err = mediaService.GetMedia(ctx, "../../etc/passwd")
+	require.Equal(t, service.ErrMediaNotFound, err)
+
+	for _, id := range []string{media.Id, doc.Id} {
+		require.NoError(t, mediaService.RemoveMedia(ctx, id))
+		_, err = mediaService.GetMedia(ctx, id)
+		require.Equal(t, service.ErrMediaNotFound, err)
+	}
+	require.Equal(t, service.ErrMediaNotFound, mediaService.RemoveMedia(ctx, media.Id))
+
+	_, err = mediaService.ReadMedia(ctx, media, false)
+	require.Equal(t, service.ErrMediaNotFound, err)
+}
@@ -0,0 +1,435 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// title words weigh more than words in the content
+	titleTermWeight = 3
+
+	maxTermLength = 64
+
+	snippetWords   = 30
+	snippetContext = 8
+)
+
+var (
+	scriptTags  = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlTags    = regexp.MustCompile(`(?s)<[^>]*>`)
+	whiteSpaces = regexp.MustCompile(`\s+`)
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+type textWord struct {
+	term  string  // stem of the lower case word
+	start int
+	end   int
+}
+
+// splits text to words, each word keeps the position in the text for snippets
+func splitWords(text string) []textWord {
+
+	var words []textWord
+	start := -1
+	for i, ch := range text {
+		if unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+		if start != -1 {
+			words = append(words, newTextWord(text, start, i))
+			start = -1
+		}
+	}
+	if start != -1 {
+		words = append(words, newTextWord(text, start, len(text)))
+	}
+	return words
+}
+
+func newTextWord(text string, start, end int) textWord {
+	word := strings.ToLower(text[start:end])
+	if stopWords[word] || len(word) > maxTermLength {
+		word = ""
+	} else {
+		word = utils.Stem(word)
+	}
+	return textWord{term: word, start: start, end: end}
+}
+
+// visible text of the rendered page, the cache is not used because the transaction may be rolled back
+func (t *implPageService) pageText(page *pb.PageEntity) string {
+	content := page.Content
+	if t.RenderService != nil {
+		if html, _, err := t.RenderService.Render(page.ContentType, page.Content); err == nil {
+			content = html
+		}
+	}
+	content = scriptTags.ReplaceAllString(content, " ")
+	content = htmlTags.ReplaceAllString(content, " ")
+	content = html.UnescapeString(content)
+	return strings.TrimSpace(whiteSpaces.ReplaceAllString(content, " "))
+}
+
+// replaces postings of the page, must be called in the transaction of the page change
+func (t *implPageService) indexPage(ctx context.Context, page *pb.PageEntity) error {
+
+	err := t.unindexPage(ctx, page.Name)
+	if err != nil {
+		return err
+	}
+
+	text := t.pageText(page)
+
+	postings := make(map[string]*pb.PageTermEntity)
+	posting := func(term string) *pb.PageTermEntity {
+		p, ok := postings[term]
+		if !ok {
+			p = new(pb.PageTermEntity)
+			postings[term] = p
+		}
+		return p
+	}
+
+	for _, w := range splitWords(page.Title) {
+		if w.term != "" {
+			posting(w.term).TitleCount++
+		}
+	}
+	for _, w := range splitWords(text) {
+		if w.term != "" {
+			posting(w.term).BodyCount++
+		}
+	}
+
+	index := &pb.PageIndexEntity{
+		Name: page.Name,
+		Text: text,
+	}
+
+	for term, p := range postings {
+		index.Terms = append(index.Terms, term)
+		err = t.HostStorage.Set(ctx).ByKey("page-term:%s:%s", term, page.Name).Proto(p)
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(index.Terms)
+
+	return t.HostStorage.Set(ctx).ByKey("page-index:%s", page.Name).Proto(index)
+}
+
+func (t *implPageService) unindexPage(ctx context.Context, name string) error {
+
+	index := new(pb.PageIndexEntity)
+	err := t.HostStorage.Get(ctx).ByKey("page-index:%s", name).ToProto(index)
+	if err != nil || index.Name == "" {
+		return err
+	}
+
+	for _, term := range index.Terms {
+		err = t.HostStorage.Remove(ctx).ByKey("page-term:%s:%s", term, name).Do()
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.HostStorage.Remove(ctx).ByKey("page-index:%s", name).Do()
+}
+
+type searchHit struct {
+	name    string
+	matched int
+	score   float64
+}
+
+func (t *implPageService) SearchPages(ctx context.Context, query string, offset, limit int, now int64, viewer *sprint.AuthorizedUser) (int, []*pb.SearchResult, error) {
+
+	terms := make(map[string]bool)
+	for _, w := range splitWords(query) {
+		if w.term != "" {
+			terms[w.term] = true
+		}
+	}
+	if len(terms) == 0 {
+		return 0, nil, nil
+	}
+
+	total, err := t.countIndexed(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hits := make(map[string]*searchHit)
+	for term := range terms {
+
+		prefix := "page-term:" + term + ":"
+		postings := make(map[string]*pb.PageTermEntity)
+		err = t.HostStorage.Enumerate(ctx).
+			ByPrefix(prefix).
+			WithBatchSize(BatchSize).
+			DoProto(func() proto.Message {
+				return new(pb.PageTermEntity)
+			}, func(entry *store.ProtoEntry) bool {
+				if v, ok := entry.Value.(*pb.PageTermEntity); ok {
+					postings[strings.TrimPrefix(string(entry.Key), prefix)] = v
+				}
+				return true
+			})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// tf-idf with the logarithmic term frequency
+		idf := math.Log(1 + float64(total) / float64(len(postings)+1))
+		for name, p := range postings {
+			hit, ok := hits[name]
+			if !ok {
+				hit = &searchHit{name: name}
+				hits[name] = hit
+			}
+			tf := float64(p.TitleCount * titleTermWeight + p.BodyCount)
+			hit.matched++
+			hit.score += (1 + math.Log(tf)) * idf
+		}
+	}
+
+	var list []*searchHit
+	for _, hit := range hits {
+		list = append(list, hit)
+	}
+
+	// pages having all words of the query go first
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].matched != list[j].matched {
+			return list[i].matched > list[j].matched
+		}
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].name < list[j].name
+	})
+
+	var pages []*pb.PageEntity
+	for _, hit := range list {
+		page, err := t.GetPage(ctx, hit.name)
+		if err == ErrPageNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if IsPagePublic(page, now) && CanViewPage(page, viewer) {
+			pages = append(pages, page)
+		}
+	}
+
+	var items []*pb.SearchResult
+	for i := offset; i < len(pages) && len(items) < limit; i++ {
+
+		index := new(pb.PageIndexEntity)
+		err = t.HostStorage.Get(ctx).ByKey("page-index:%s", pages[i].Name).ToProto(index)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		items = append(items, &pb.SearchResult{
+			Position: int32(i + 1),
+			Name:     pages[i].Name,
+			Title:    pages[i].Title,
+			Snippet:  buildSnippet(index.Text, terms),
+		})
+	}
+
+	return len(pages), items, nil
+}
+
+func (t *implPageService) countIndexed(ctx context.Context) (cnt int, err error) {
+	err = t.HostStorage.Enumerate(ctx).
+		ByPrefix("page-index:").
+		OnlyKeys().
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageIndexEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			cnt++
+			return true
+		})
+	return
+}
+
+// html escaped fragment of the text around the first matched word, matched words are in <mark> tags
+func buildSnippet(text string, terms map[string]bool) string {
+
+	words := splitWords(text)
+	if len(words) == 0 {
+		return ""
+	}
+
+	start := 0
+	for i, w := range words {
+		if terms[w.term] {
+			if i > snippetContext {
+				start = i - snippetContext
+			}
+			break
+		}
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	var out strings.Builder
+	if start > 0 {
+		out.WriteString("… ")
+	}
+	pos := words[start].start
+	for _, w := range words[start:end] {
+		out.WriteString(html.EscapeString(text[pos:w.start]))
+		if terms[w.term] {
+			out.WriteString("<mark>")
+			out.WriteString(html.EscapeString(text[w.start:w.end]))
+			out.WriteString("</mark>")
+		} else {
+			out.WriteString(html.EscapeString(text[w.start:w.end]))
+		}
+		pos = w.end
+	}
+	if end < len(words) {
+		out.WriteString(" …")
+	} else {
+		out.WriteString(html.EscapeString(text[pos:]))
+	}
+	return out.String()
+}
+
+func (t *implPageService) RebuildIndex(ctx context.Context) (int, error) {
+
+	for _, prefix := range []string{"page-index:", "page-term:"} {
+		if err := t.removeByPrefix(ctx, prefix); err != nil {
+			return 0, err
+		}
+	}
+
+	var names []string
+	err := t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		names = append(names, page.Name)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		if err := t.reindexPage(ctx, name); err != nil {
+			return 0, err
+		}
+	}
+
+	t.Log.Info("RebuildPageIndex", zap.Int("pages", len(names)))
+	return len(names), nil
+}
+
+func (t *implPageService) reindexPage(ctx context.Context, name string) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	page, err := t.GetPage(ctx, name)
+	if err == ErrPageNotFound {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	return t.indexPage(ctx, page)
+}
+
+func (t *implPageService) removeByPrefix(ctx context.Context, prefix string) error {
+
+	for {
+		var keys [][]byte
+		err := t.HostStorage.Enumerate(ctx).
+			ByPrefix(prefix).
+			OnlyKeys().
+			WithBatchSize(BatchSize).
+			DoProto(func() proto.Message {
+				return new(pb.PageTermEntity)
+			}, func(entry *store.ProtoEntry) bool {
+				keys = append(keys, append([]byte(nil), entry.Key...))
+				return len(keys) < BatchSize
+			})
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			return nil
+		}
+
+		for _, key := range keys {
+			if err := t.HostStorage.Remove(ctx).ByRawKey(key).Do(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pages created before the search index are indexed on the first start
+func (t *implPageService) ensureIndex(ctx context.Context) error {
+
+	indexed, err := t.countIndexed(ctx)
+	if err != nil || indexed > 0 {
+		return err
+	}
+
+	var empty = true
+	err = t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		empty = false
+		return false
+	})
+	if err != nil || empty {
+		return err
+	}
+
+	_, err = t.RebuildIndex(ctx)
+	return err
+}
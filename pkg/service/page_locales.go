@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/utils"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"time"
+)
+
+// parses 'from=to' pairs of page.locale-fallback
+func (t *implPageService) initLocales() error {
+
+	t.defaultLocale = utils.NormalizeLocale(t.DefaultLocaleTag)
+	if t.defaultLocale == "" {
+		return errors.Errorf("invalid default locale '%s'", t.DefaultLocaleTag)
+	}
+
+	t.fallbacks = make(map[string]string)
+	for _, pair := range t.LocaleFallback {
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 || utils.NormalizeLocale(kv[0]) == "" || utils.NormalizeLocale(kv[1]) == "" {
+			return errors.Errorf("invalid locale fallback '%s', expected 'from=to'", pair)
+		}
+		t.fallbacks[utils.NormalizeLocale(kv[0])] = utils.NormalizeLocale(kv[1])
+	}
+	return nil
+}
+
+func (t *implPageService) DefaultLocale() string {
+	return t.defaultLocale
+}
+
+// empty string for the default locale
+func (t *implPageService) pageLocale(locale string) string {
+	locale = utils.NormalizeLocale(locale)
+	if locale == t.defaultLocale {
+		return ""
+	}
+	return locale
+}
+
+// each locale is followed by the configured fallback or by its language, like pt-br by pt
+func (t *implPageService) LocaleChain(requested []string) []string {
+
+	var chain []string
+	seen := make(map[string]bool)
+	for _, locale := range requested {
+		locale = utils.NormalizeLocale(locale)
+		for locale != "" && !seen[locale] {
+			if locale == t.defaultLocale {
+				return chain
+			}
+			seen[locale] = true
+			chain = append(chain, locale)
+			next, ok := t.fallbacks[locale]
+			if !ok {
+				next = utils.LocaleLanguage(locale)
+			}
+			locale = next
+		}
+	}
+	return chain
+}
+
+func (t *implPageService) GetPageLocale(ctx context.Context, name, locale string) (*pb.PageEntity, error) {
+
+	locale = t.pageLocale(locale)
+	if locale == "" {
+		return t.GetPage(ctx, name)
+	}
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return nil, errors.New("page name is empty")
+	}
+
+	page := new(pb.PageEntity)
+	err := t.HostStorage.Get(ctx).ByKey("page:%s:%s", name, locale).ToProto(page)
+	if err != nil {
+		return nil, err
+	}
+	if page.Name == "" {
+		return nil, ErrPageNotFound
+	}
+	return page, nil
+}
+
+func (t *implPageService) PageLocales(ctx context.Context, name string) ([]string, error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return nil, errors.New("page name is empty")
+	}
+
+	prefix := "page:" + name + ":"
+	var locales []string
+	err := t.HostStorage.Enumerate(ctx).
+		ByPrefix(prefix).
+		OnlyKeys().
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			locales = append(locales, strings.TrimPrefix(string(entry.Key), prefix))
+			return true
+		})
+
+	return locales, err
+}
+
+// creates or updates the translation, the default page must exist
+func (t *implPageService) saveLocale(ctx context.Context, req *pb.AdminPage, locale, authorId string, create bool) (err error) {
+
+	if prev := utils.NormalizePageId(req.Prev); prev != "" && prev != req.Name {
+		return errors.New("nowrap: translation can not be renamed, rename the default page instead")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	_, err = t.GetPage(ctx, req.Name)
+	if err == ErrPageNotFound {
+		err = errors.Errorf("nowrap: page '%s' in the default locale '%s' must exist before translations", req.Name, t.defaultLocale)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	current := new(pb.PageEntity)
+	err = t.HostStorage.Get(ctx).ByKey("page:%s:%s", req.Name, locale).ToProto(current)
+	if err != nil {
+		return
+	}
+
+	if create && current.Name != "" {
+		err = errors.Errorf("nowrap: translation '%s' of page '%s' already exist", locale, req.Name)
+		return
+	}
+	if !create && current.Name != "" && current.Version != req.Version {
+		err = errors.Wrapf(ErrVersionConflict, "translation '%s' of page '%s' was changed by another user, current version is %d", locale, req.Name, current.Version)
+		return
+	}
+
+	contentType, err := t.parseContentType(req.ContentType)
+	if err != nil {
+		err = errors.Errorf("nowrap: invalid content type '%s'", req.ContentType)
+		return
+	}
+
+	err = t.validateContent(contentType, req.Content)
+	if err != nil {
+		return
+	}
+
+	entity := &pb.PageEntity{
+		Name:         req.Name,
+		Title:        req.Title,
+		Content:      req.Content,
+		ContentType:  contentType,
+		Locale:       locale,
+		Status:       pb.PageStatus_DRAFT,
+	}
+	if current.Name != "" {
+		entity.Status = current.Status
+		entity.PublishAt = current.PublishAt
+		entity.UnpublishAt = current.UnpublishAt
+	}
+	touchPage(entity, current, authorId)
+
+	err = t.applyStatus(entity, req)
+	if err != nil {
+		return
+	}
+
+	err = applyMeta(entity, req)
+	if err != nil {
+		return
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("page:%s:%s", req.Name, locale).Proto(entity)
+	if err != nil {
+		return
+	}
+
+	t.invalidate(req.Name)
+	return
+}
+
+func (t *implPageService) RemovePageLocale(ctx context.Context, name, locale string) error {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return errors.New("page name is empty")
+	}
+
+	locale = t.pageLocale(locale)
+	if locale == "" {
+		return errors.Errorf("nowrap: page in the default locale '%s' is not a translation, remove the page instead", t.defaultLocale)
+	}
+
+	err := t.HostStorage.Remove(ctx).ByKey("page:%s:%s", name, locale).Do()
+	if err != nil {
+		return err
+	}
+
+	t.invalidate(name)
+	return nil
+}
+
+func (t *implPageService) removeLocales(ctx context.Context, name string) error {
+
+	locales, err := t.PageLocales(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, locale := range locales {
+		err = t.HostStorage.Remove(ctx).ByKey("page:%s:%s", name, locale).Do()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translations follow the renamed page
+func (t *implPageService) moveLocales(ctx context.Context, from, to, authorId string) error {
+
+	var list []*pb.PageEntity
+	err := t.HostStorage.Enumerate(ctx).
+		ByPrefix("page:%s:", from).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageEntity); ok && v.Locale != "" {
+				list = append(list, v)
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, page := range list {
+
+		err = t.HostStorage.Remove(ctx).ByKey("page:%s:%s", from, page.Locale).Do()
+		if err != nil {
+			return err
+		}
+
+		page.Name = to
+		page.UpdTimestamp = now
+		page.UpdatedBy = authorId
+		page.Version++
+
+		err = t.HostStorage.Set(ctx).ByKey("page:%s:%s", to, page.Locale).Proto(page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
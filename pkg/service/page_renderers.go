@@ -0,0 +1,301 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/pkg/errors"
+	"html"
+	"strings"
+)
+
+// names of markdown.extensions, includes and mmark are not supported, they read files
+var markdownExtensions = map[string]parser.Extensions{
+	"no-intra-emphasis":          parser.NoIntraEmphasis,
+	"tables":                     parser.Tables,
+	"fenced-code":                parser.FencedCode,
+	"autolink":                   parser.Autolink,
+	"strikethrough":              parser.Strikethrough,
+	"lax-html-blocks":            parser.LaxHTMLBlocks,
+	"space-headings":             parser.SpaceHeadings,
+	"hard-line-break":            parser.HardLineBreak,
+	"footnotes":                  parser.Footnotes,
+	"no-empty-line-before-block": parser.NoEmptyLineBeforeBlock,
+	"heading-ids":                parser.HeadingIDs,
+	"auto-heading-ids":           parser.AutoHeadingIDs,
+	"backslash-line-break":       parser.BackslashLineBreak,
+	"definition-lists":           parser.DefinitionLists,
+	"mathjax":                    parser.MathJax,
+	"ordered-list-start":         parser.OrderedListStart,
+	"attributes":                 parser.Attributes,
+	"super-subscript":            parser.SuperSubscript,
+}
+
+// paragraph replaced by the table of contents
+const tocMarker = "<p>[TOC]</p>"
+
+type implMarkdownRenderer struct {
+	Extensions  []string  `value:"markdown.extensions,default=no-intra-emphasis;tables;fenced-code;autolink;strikethrough;space-headings;heading-ids;auto-heading-ids;backslash-line-break;definition-lists;mathjax;footnotes"`
+	TocDepth    int       `value:"markdown.toc-depth,default=3"`
+
+	extensions  parser.Extensions
+}
+
+func MarkdownRenderer() api.PageRenderer {
+	return &implMarkdownRenderer{}
+}
+
+func (t *implMarkdownRenderer) PostConstruct() error {
+	for _, name := range t.Extensions {
+		ext, ok := markdownExtensions[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return errors.Errorf("unknown markdown extension '%s'", name)
+		}
+		t.extensions |= ext
+	}
+	return nil
+}
+
+func (t *implMarkdownRenderer) ContentType() pb.ContentType {
+	return pb.ContentType_MARKDOWN
+}
+
+// parser keeps the state, so it is created for each page
+func (t *implMarkdownRenderer) Render(content string) (string, error) {
+
+	doc := markdown.Parse(markdown.NormalizeNewlines([]byte(content)), parser.NewWithExtensions(t.extensions))
+	headings := collectHeadings(doc)
+
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags: mdhtml.CommonFlags | mdhtml.FootnoteReturnLinks,
+	})
+	out := string(markdown.Render(doc, renderer))
+
+	if strings.Contains(out, tocMarker) {
+		out = strings.Replace(out, tocMarker, renderToc(headings, t.TocDepth), 1)
+	}
+	return out, nil
+}
+
+type tocHeading struct {
+	level  int
+	id     string
+	text   string
+}
+
+// makes heading ids unique, the renderer does the same, so links of the toc match
+func collectHeadings(doc ast.Node) []tocHeading {
+
+	var list []tocHeading
+	taken := make(map[string]bool)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || heading.HeadingID == "" || heading.IsTitleblock {
+			return ast.GoToNext
+		}
+
+		id := heading.HeadingID
+		for n := 1; taken[id]; n++ {
+			id = fmt.Sprintf("%s-%d", heading.HeadingID, n)
+		}
+		taken[id] = true
+		heading.HeadingID = id
+
+		var text strings.Builder
+		ast.WalkFunc(heading, func(node ast.Node, entering bool) ast.WalkStatus {
+			if leaf := node.AsLeaf(); entering && leaf != nil {
+				switch node.(type) {
+				case *ast.Text, *ast.Code:
+					text.Write(leaf.Literal)
+				}
+			}
+			return ast.GoToNext
+		})
+
+		list = append(list, tocHeading{level: heading.Level, id: id, text: strings.TrimSpace(text.String())})
+		return ast.SkipChildren
+	})
+	return list
+}
+
+// nested lists of headings up to the depth
+func renderToc(headings []tocHeading, depth int) string {
+
+	var out strings.Builder
+	out.WriteString(`<nav class="toc">`)
+
+	var levels []int
+	for _, h := range headings {
+		if h.level > depth {
+			continue
+		}
+		for len(levels) > 0 && levels[len(levels)-1] > h.level {
+			out.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && levels[len(levels)-1] == h.level {
+			out.WriteString("</li>")
+		} else {
+			out.WriteString("<ul>")
+			levels = append(levels, h.level)
+		}
+		out.WriteString(fmt.Sprintf(`<li><a href="#%s">%s</a>`, html.EscapeString(h.id), html.EscapeString(h.text)))
+	}
+	for range levels {
+		out.WriteString("</li></ul>")
+	}
+
+	out.WriteString("</nav>")
+	return out.String()
+}
+
+type implHtmlRenderer struct {
+}
+
+func HtmlRenderer() api.PageRenderer {
+	return &implHtmlRenderer{}
+}
+
+func (t *implHtmlRenderer) ContentType() pb.ContentType {
+	return pb.ContentType_HTML
+}
+
+func (t *implHtmlRenderer) Render(content string) (string, error) {
+	return content, nil
+}
+
+// paragraphs are separated by empty lines
+type implPlainTextRenderer struct {
+}
+
+func PlainTextRenderer() api.PageRenderer {
+	return &implPlainTextRenderer{}
+}
+
+func (t *implPlainTextRenderer) ContentType() pb.ContentType {
+	return pb.ContentType_PLAIN_TEXT
+}
+
+func (t *implPlainTextRenderer) Render(content string) (string, error) {
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	var out strings.Builder
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph != "" {
+			out.WriteString("<p>")
+			out.WriteString(textToHtml(paragraph))
+			out.WriteString("</p>\n")
+		}
+	}
+	return out.String(), nil
+}
+
+func textToHtml(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+type jsonBlocks struct {
+	Blocks []jsonBlock `json:"blocks"`
+}
+
+type jsonBlock struct {
+	Type    string `json:"type"`     // heading, paragraph, image or callout
+	Text    string `json:"text"`
+	Level   int    `json:"level"`    // heading level 1-6, 2 by default
+	Src     string `json:"src"`      // image url
+	Alt     string `json:"alt"`
+	Caption string `json:"caption"`
+	Style   string `json:"style"`    // callout style: info, success, warning or danger
+}
+
+var calloutStyles = map[string]bool{
+	"info": true, "success": true, "warning": true, "danger": true,
+}
+
+// structured content of the block editor
+type implJsonBlocksRenderer struct {
+}
+
+func JsonBlocksRenderer() api.PageRenderer {
+	return &implJsonBlocksRenderer{}
+}
+
+func (t *implJsonBlocksRenderer) ContentType() pb.ContentType {
+	return pb.ContentType_JSON_BLOCKS
+}
+
+func (t *implJsonBlocksRenderer) Render(content string) (string, error) {
+
+	var doc jsonBlocks
+	if strings.TrimSpace(content) != "" {
+		if err := json.Unmarshal([]byte(content), &doc); err != nil {
+			return "", errors.Errorf("invalid json blocks, %v", err)
+		}
+	}
+
+	var out strings.Builder
+	for i, block := range doc.Blocks {
+		switch block.Type {
+
+		case "heading":
+			level := block.Level
+			if level == 0 {
+				level = 2
+			}
+			if level < 1 || level > 6 {
+				return "", errors.Errorf("block %d has invalid heading level %d", i, block.Level)
+			}
+			out.WriteString(fmt.Sprintf("<h%d>%s</h%d>\n", level, html.EscapeString(block.Text), level))
+
+		case "paragraph":
+			out.WriteString(fmt.Sprintf("<p>%s</p>\n", textToHtml(block.Text)))
+
+		case "image":
+			if block.Src == "" {
+				return "", errors.Errorf("block %d has empty image src", i)
+			}
+			out.WriteString("<figure>")
+			out.WriteString(fmt.Sprintf(`<img src="%s" alt="%s"/>`, html.EscapeString(block.Src), html.EscapeString(block.Alt)))
+			if block.Caption != "" {
+				out.WriteString(fmt.Sprintf("<figcaption>%s</figcaption>", html.EscapeString(block.Caption)))
+			}
+			out.WriteString("</figure>\n")
+
+		case "callout":
+			style := block.Style
+			if style == "" {
+				style = "info"
+			}
+			if !calloutStyles[style] {
+				return "", errors.Errorf("block %d has unknown callout style '%s'", i, block.Style)
+			}
+			out.WriteString(fmt.Sprintf(`<div class="notification is-%s">%s</div>`, style, textToHtml(block.Text)))
+			out.WriteByte('\n')
+
+		default:
+			return "", errors.Errorf("block %d has unknown type '%s'", i, block.Type)
+		}
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/template/pkg/api"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const publishPagesJob = "publish-pages"
+
+type implPageScheduler struct {
+	Log            *zap.Logger          `inject`
+	PageService    api.PageService      `inject`
+	JobService     sprint.JobService    `inject:"optional"`
+
+	IntervalSeconds  int   `value:"page.scheduler-interval-seconds,default=60"`
+
+	done       chan struct{}
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+func PageScheduler() api.PageScheduler {
+	return &implPageScheduler{}
+}
+
+func (t *implPageScheduler) PostConstruct() error {
+
+	if t.JobService != nil {
+		// allows to run the job manually by 'job run publish-pages' command
+		err := t.JobService.AddJob(&sprint.JobInfo{
+			Name:        publishPagesJob,
+			ExecutionFn: t.publish,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.IntervalSeconds <= 0 {
+		return nil
+	}
+
+	t.done = make(chan struct{})
+	t.wg.Add(1)
+	go t.run()
+	return nil
+}
+
+func (t *implPageScheduler) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(t.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := t.publish(context.Background()); err != nil {
+			t.Log.Error("PageScheduler", zap.Error(err))
+		}
+
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *implPageScheduler) publish(ctx context.Context) error {
+	cnt, err := t.PageService.PublishScheduled(ctx, time.Now().Unix())
+	if cnt > 0 {
+		t.Log.Info("PageScheduler", zap.Int("changed", cnt))
+	}
+	return err
+}
+
+func (t *implPageScheduler) Destroy() error {
+	t.closeOnce.Do(func() {
+		if t.done != nil {
+			close(t.done)
+			t.wg.Wait()
+		}
+	})
+	return nil
+}
@@ -16,13 +16,17 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/pkg/errors"
+	"github.com/codeallergy/sprint"
 	"github.com/codeallergy/store"
 	"github.com/codeallergy/template/pkg/api"
 	"github.com/codeallergy/template/pkg/pb"
 	"github.com/codeallergy/template/pkg/utils"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
@@ -31,12 +35,115 @@ type implPageService  struct {
 	Log            *zap.Logger          `inject`
 	HostStorage    store.DataStore      `inject:"bean=host-storage"`
 	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+	RenderService  api.RenderService    `inject:"optional"`
+
+	MaxRevisions   int   `value:"page.max-revisions,default=50"`
+
+	// locale of pages stored in page:%s, translations are in page:%s:%s
+	DefaultLocaleTag  string    `value:"page.default-locale,default=en"`
+	LocaleFallback    []string  `value:"page.locale-fallback,default="`
+
+	defaultLocale  string
+	fallbacks      map[string]string
 }
 
 func PageService() api.PageService {
 	return &implPageService{}
 }
 
+func (t *implPageService) PostConstruct() error {
+	if err := t.initLocales(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := t.migrateTimestamps(ctx); err != nil {
+		return err
+	}
+	return t.ensureIndex(ctx)
+}
+
+// Pages saved before upd_timestamp was introduced have the last update time in cre_timestamp.
+// The creation time and authors are taken from the revision history when it exists.
+func (t *implPageService) migrateTimestamps(ctx context.Context) error {
+
+	var names []string
+	err := t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		if page.UpdTimestamp == 0 {
+			names = append(names, page.Name)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := t.migratePage(ctx, name); err != nil {
+			return errors.Wrapf(err, "migrate page '%s'", name)
+		}
+	}
+
+	if len(names) > 0 {
+		t.Log.Info("MigratePageTimestamps", zap.Int("pages", len(names)))
+	}
+	return nil
+}
+
+func (t *implPageService) migratePage(ctx context.Context, name string) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	page := new(pb.PageEntity)
+	err = t.HostStorage.Get(ctx).ByKey("page:%s", name).ToProto(page)
+	if err != nil || page.Name == "" || page.UpdTimestamp != 0 {
+		return
+	}
+
+	page.UpdTimestamp = page.CreTimestamp
+
+	var first, last *pb.PageRevisionEntity
+	err = t.EnumPageRevisions(ctx, name, func(rev *pb.PageRevisionEntity) bool {
+		if first == nil {
+			first = rev
+		}
+		last = rev
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	// history could be trimmed, so only the first revision tells the creator
+	if first != nil && first.Revision == 1 && first.CreTimestamp > 0 && first.CreTimestamp < page.CreTimestamp {
+		page.CreTimestamp = first.CreTimestamp
+		page.CreatedBy = first.AuthorId
+	}
+	if last != nil {
+		page.UpdatedBy = last.AuthorId
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("page:%s", name).Proto(page)
+	return
+}
+
+// keeps creation info of the current page and increments the version, current is empty for the new one
+func touchPage(entity, current *pb.PageEntity, authorId string) {
+	now := time.Now().Unix()
+	if current.Name == "" {
+		entity.CreTimestamp = now
+		entity.CreatedBy = authorId
+	} else {
+		entity.CreTimestamp = current.CreTimestamp
+		entity.CreatedBy = current.CreatedBy
+	}
+	entity.UpdTimestamp = now
+	entity.UpdatedBy = authorId
+	entity.Version = current.Version + 1
+}
+
 func (t *implPageService) GetPage(ctx context.Context, name string) (*pb.PageEntity, error) {
 
 	name = utils.NormalizePageId(name)
@@ -63,13 +170,17 @@ func (t *implPageService) GetPage(ctx context.Context, name string) (*pb.PageEnt
 
 }
 
-func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage) (err error) {
+func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage, authorId string) (err error) {
 
 	newPage.Name = utils.NormalizePageId(newPage.Name)
 	if newPage.Name == "" {
 		return errors.New("new page name is empty")
 	}
 
+	if locale := t.pageLocale(newPage.Locale); locale != "" {
+		return t.saveLocale(ctx, newPage, locale, authorId, true)
+	}
+
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
@@ -89,56 +200,115 @@ func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage)
 	contentType, err := t.parseContentType(newPage.ContentType)
 	if err != nil {
 		err = errors.Errorf("nowrap: invalid content type '%s'", newPage.ContentType)
+		return
+	}
+
+	err = t.validateContent(contentType, newPage.Content)
+	if err != nil {
+		return
 	}
 
+	now := time.Now().Unix()
 	entity = &pb.PageEntity{
 		Name:         newPage.Name,
 		Title:        newPage.Title,
 		Content:      newPage.Content,
 		ContentType:  contentType,
-		CreTimestamp: time.Now().Unix(),
+		CreTimestamp: now,
+		Status:       pb.PageStatus_DRAFT,
+		UpdTimestamp: now,
+		CreatedBy:    authorId,
+		UpdatedBy:    authorId,
+		Version:      1,
+	}
+
+	err = t.applyStatus(entity, newPage)
+	if err != nil {
+		return
+	}
+
+	err = applyVisibility(entity, newPage)
+	if err != nil {
+		return
+	}
+
+	err = applyMeta(entity, newPage)
+	if err != nil {
+		return
 	}
 
 	err = t.HostStorage.Set(ctx).ByKey("page:%s", newPage.Name).Proto(entity)
+	if err != nil {
+		return
+	}
+
+	err = t.indexPage(ctx, entity)
+	if err != nil {
+		return
+	}
+
+	err = t.addRevision(ctx, entity, authorId, newPage.Note)
 	return
 
 }
 
-func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.AdminPage) (err error) {
+func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.AdminPage, authorId string) (err error) {
 
 	updatingPage.Name = utils.NormalizePageId(updatingPage.Name)
 	if updatingPage.Name == "" {
 		return errors.New("updating page name is empty")
 	}
 
+	if locale := t.pageLocale(updatingPage.Locale); locale != "" {
+		return t.saveLocale(ctx, updatingPage, locale, authorId, false)
+	}
+
+	prev := utils.NormalizePageId(updatingPage.Prev)
+	if prev == "" {
+		prev = updatingPage.Name
+	}
+
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
 	}()
 
-	if updatingPage.Name != updatingPage.Prev && updatingPage.Prev != "" {
+	current := new(pb.PageEntity)
+	err = t.HostStorage.Get(ctx).ByKey("page:%s", prev).ToProto(current)
+	if err != nil {
+		return
+	}
 
-		entity := new(pb.PageEntity)
-		err = t.HostStorage.Get(ctx).ByKey("page:%s", updatingPage.Name).ToProto(entity)
-		if err != nil {
-			return
-		}
+	if current.Name != "" && current.Version != updatingPage.Version {
+		err = errors.Wrapf(ErrVersionConflict, "page '%s' was changed by another user, current version is %d", prev, current.Version)
+		return
+	}
 
-		if entity.Name != "" {
-			err = errors.Errorf("nowrap: page '%s' already exist", updatingPage.Name)
+	// pages created before the revision history get the current content as the first revision
+	if current.Name != "" {
+		err = t.ensureRevisions(ctx, current)
+		if err != nil {
 			return
 		}
+	}
 
-		err = t.HostStorage.Remove(ctx).ByKey("page:%s", updatingPage.Prev).Do()
+	// children, revisions and translations move with the page in the same transaction
+	if updatingPage.Name != prev {
+		err = t.MovePage(ctx, prev, updatingPage.Name, authorId)
 		if err != nil {
 			return
 		}
-
 	}
 
 	contentType, err := t.parseContentType(updatingPage.ContentType)
 	if err != nil {
 		err = errors.Errorf("nowrap: invalid content type '%s'", updatingPage.ContentType)
+		return
+	}
+
+	err = t.validateContent(contentType, updatingPage.Content)
+	if err != nil {
+		return
 	}
 
 	entity := &pb.PageEntity{
@@ -146,22 +316,81 @@ func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.Admin
 		Title:        updatingPage.Title,
 		Content:      updatingPage.Content,
 		ContentType:  contentType,
-		CreTimestamp: time.Now().Unix(),
+		Status:       current.Status,
+		PublishAt:    current.PublishAt,
+		UnpublishAt:  current.UnpublishAt,
+		SortOrder:    current.SortOrder,
+		Visibility:   current.Visibility,
+		Roles:        current.Roles,
+	}
+	touchPage(entity, current, authorId)
+
+	err = t.applyStatus(entity, updatingPage)
+	if err != nil {
+		return
+	}
+
+	err = applyVisibility(entity, updatingPage)
+	if err != nil {
+		return
+	}
+
+	err = applyMeta(entity, updatingPage)
+	if err != nil {
+		return
 	}
 
 	err = t.HostStorage.Set(ctx).ByKey("page:%s", updatingPage.Name).Proto(entity)
+	if err != nil {
+		return
+	}
+
+	err = t.indexPage(ctx, entity)
+	if err != nil {
+		return
+	}
+
+	t.invalidate(prev, updatingPage.Name)
+
+	err = t.addRevision(ctx, entity, authorId, updatingPage.Note)
 	return
 
 }
 
-func (t *implPageService) RemovePage(ctx context.Context, name string) error {
+func (t *implPageService) RemovePage(ctx context.Context, name string) (err error) {
 
 	name = utils.NormalizePageId(name)
 	if name == "" {
 		return errors.New("page name is empty")
 	}
 
-	return t.HostStorage.Remove(ctx).ByKey("page:%s", name).Do()
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	err = t.HostStorage.Remove(ctx).ByKey("page:%s", name).Do()
+	if err != nil {
+		return
+	}
+
+	err = t.removeLocales(ctx, name)
+	if err != nil {
+		return
+	}
+
+	t.invalidate(name)
+
+	return t.unindexPage(ctx, name)
+}
+
+// cached content is keyed by the version, removal just frees the memory
+func (t *implPageService) invalidate(names ...string) {
+	if t.RenderService != nil {
+		for _, name := range names {
+			t.RenderService.Invalidate(name)
+		}
+	}
 }
 
 func (t *implPageService) EnumPages(ctx context.Context, cb func(page *pb.PageEntity) bool) error {
@@ -172,7 +401,7 @@ func (t *implPageService) EnumPages(ctx context.Context, cb func(page *pb.PageEn
 		DoProto(func() proto.Message {
 			return new(pb.PageEntity)
 		}, func(entry *store.ProtoEntry) bool {
-			if v, ok := entry.Value.(*pb.PageEntity); ok {
+			if v, ok := entry.Value.(*pb.PageEntity); ok && v.Locale == "" {
 				return cb(v)
 			}
 			return true
@@ -180,16 +409,820 @@ func (t *implPageService) EnumPages(ctx context.Context, cb func(page *pb.PageEn
 
 }
 
-func (t *implPageService) parseContentType(ct string) (pb.ContentType, error) {
-	contentType := pb.ContentType_MARKDOWN
-	switch strings.ToUpper(strings.TrimSpace(ct)) {
-	case "MARKDOWN":
-		contentType = pb.ContentType_MARKDOWN
-	case "HTML":
-		contentType = pb.ContentType_HTML
-	default:
-		return 0, errors.Errorf("invalid content type '%s'", ct)
+func (t *implPageService) EnumPageRevisions(ctx context.Context, name string, cb func(revision *pb.PageRevisionEntity) bool) error {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return errors.New("page name is empty")
+	}
+
+	return t.HostStorage.Enumerate(ctx).
+		ByPrefix("page-revision:%s:", name).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageRevisionEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageRevisionEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implPageService) GetPageRevision(ctx context.Context, name string, revision int64) (*pb.PageRevisionEntity, error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return nil, errors.New("page name is empty")
+	}
+
+	entity := new(pb.PageRevisionEntity)
+	err := t.HostStorage.Get(ctx).ByKey("page-revision:%s:%010d", name, revision).ToProto(entity)
+	if err != nil {
+		return nil, err
+	}
+	if entity.Revision == 0 {
+		return nil, ErrPageRevisionNotFound
+	}
+	return entity, nil
+}
+
+func (t *implPageService) RestorePageRevision(ctx context.Context, name string, revision int64, authorId string) (err error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return errors.New("page name is empty")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	rev, err := t.GetPageRevision(ctx, name, revision)
+	if err != nil {
+		return
+	}
+
+	// publication status and metadata are not the part of the revision, restored removed page is a draft
+	current := &pb.PageEntity{Status: pb.PageStatus_DRAFT}
+	err = t.HostStorage.Get(ctx).ByKey("page:%s", name).ToProto(current)
+	if err != nil {
+		return
+	}
+
+	entity := &pb.PageEntity{
+		Name:         name,
+		Title:        rev.Title,
+		Content:      rev.Content,
+		ContentType:  rev.ContentType,
+		Status:       current.Status,
+		PublishAt:    current.PublishAt,
+		UnpublishAt:  current.UnpublishAt,
+		SortOrder:    current.SortOrder,
+		Description:  current.Description,
+		Keywords:     current.Keywords,
+		OgTitle:      current.OgTitle,
+		OgImage:      current.OgImage,
+		CanonicalUrl: current.CanonicalUrl,
+		Noindex:      current.Noindex,
+		Layout:       current.Layout,
+		Visibility:   current.Visibility,
+		Roles:        current.Roles,
+		Category:     current.Category,
+		Tags:         current.Tags,
+	}
+	touchPage(entity, current, authorId)
+
+	err = t.HostStorage.Set(ctx).ByKey("page:%s", name).Proto(entity)
+	if err != nil {
+		return
+	}
+
+	err = t.indexPage(ctx, entity)
+	if err != nil {
+		return
+	}
+
+	t.invalidate(name)
+
+	err = t.addRevision(ctx, entity, authorId, fmt.Sprintf("restored revision %d", revision))
+	return
+}
+
+func (t *implPageService) lastRevision(ctx context.Context, name string) (last int64, err error) {
+	err = t.HostStorage.Enumerate(ctx).ByPrefix("page-revision:%s:", name).
+		Seek("page-revision:%s:\xff", name).
+		Reverse().
+		WithBatchSize(1).
+		DoProto(func() proto.Message {
+			return new(pb.PageRevisionEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageRevisionEntity); ok {
+				last = v.Revision
+			}
+			return false
+		})
+	return
+}
+
+func (t *implPageService) addRevision(ctx context.Context, page *pb.PageEntity, authorId, note string) error {
+
+	last, err := t.lastRevision(ctx, page.Name)
+	if err != nil {
+		return err
+	}
+
+	rev := &pb.PageRevisionEntity{
+		Name:         page.Name,
+		Revision:     last + 1,
+		Title:        page.Title,
+		Content:      page.Content,
+		ContentType:  page.ContentType,
+		AuthorId:     authorId,
+		CreTimestamp: time.Now().Unix(),
+		Note:         note,
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("page-revision:%s:%010d", page.Name, rev.Revision).Proto(rev)
+	if err != nil {
+		return err
+	}
+
+	return t.trimRevisions(ctx, page.Name, rev.Revision - int64(t.MaxRevisions))
+}
+
+func (t *implPageService) ensureRevisions(ctx context.Context, page *pb.PageEntity) error {
+
+	last, err := t.lastRevision(ctx, page.Name)
+	if err != nil || last > 0 {
+		return err
+	}
+
+	rev := &pb.PageRevisionEntity{
+		Name:         page.Name,
+		Revision:     1,
+		Title:        page.Title,
+		Content:      page.Content,
+		ContentType:  page.ContentType,
+		CreTimestamp: page.CreTimestamp,
+	}
+
+	return t.HostStorage.Set(ctx).ByKey("page-revision:%s:%010d", page.Name, rev.Revision).Proto(rev)
+}
+
+// removes revisions up to the given one including
+func (t *implPageService) trimRevisions(ctx context.Context, name string, upTo int64) error {
+
+	if t.MaxRevisions <= 0 || upTo <= 0 {
+		return nil
+	}
+
+	var keys [][]byte
+	err := t.HostStorage.Enumerate(ctx).
+		ByPrefix("page-revision:%s:", name).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageRevisionEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageRevisionEntity); ok && v.Revision > upTo {
+				return false
+			}
+			keys = append(keys, append([]byte(nil), entry.Key...))
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := t.HostStorage.Remove(ctx).ByRawKey(key).Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *implPageService) moveRevisions(ctx context.Context, from, to string) error {
+
+	var list []*pb.PageRevisionEntity
+	err := t.EnumPageRevisions(ctx, from, func(rev *pb.PageRevisionEntity) bool {
+		list = append(list, rev)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range list {
+		err = t.HostStorage.Remove(ctx).ByKey("page-revision:%s:%010d", from, rev.Revision).Do()
+		if err != nil {
+			return err
+		}
+		rev.Name = to
+		err = t.HostStorage.Set(ctx).ByKey("page-revision:%s:%010d", to, rev.Revision).Proto(rev)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *implPageService) MovePage(ctx context.Context, name, newName, authorId string) (err error) {
+
+	name = utils.NormalizePageId(name)
+	newName = utils.NormalizePageId(newName)
+	if name == "" || newName == "" {
+		return errors.New("page name is empty")
+	}
+	if name == newName {
+		return nil
+	}
+	if strings.HasPrefix(newName + "/", name + "/") {
+		return errors.Errorf("nowrap: page '%s' can not be moved inside itself", name)
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	page, err := t.GetPage(ctx, name)
+	if err != nil {
+		return
+	}
+
+	// new parent has own order of children
+	page.SortOrder = 0
+	list := []*pb.PageEntity{page}
+
+	err = t.HostStorage.Enumerate(ctx).
+		ByPrefix("page:%s/", name).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			// translations are moved with their pages
+			if v, ok := entry.Value.(*pb.PageEntity); ok && v.Locale == "" {
+				list = append(list, v)
+			}
+			return true
+		})
+	if err != nil {
+		return
+	}
+
+	for _, page := range list {
+		target := newName + strings.TrimPrefix(page.Name, name)
+
+		existing := new(pb.PageEntity)
+		err = t.HostStorage.Get(ctx).ByKey("page:%s", target).ToProto(existing)
+		if err != nil {
+			return
+		}
+		if existing.Name != "" {
+			err = errors.Errorf("nowrap: page '%s' already exist", target)
+			return
+		}
+	}
+
+	now := time.Now().Unix()
+	for _, page := range list {
+		prev := page.Name
+		page.Name = newName + strings.TrimPrefix(prev, name)
+		page.UpdTimestamp = now
+		page.UpdatedBy = authorId
+		page.Version++
+
+		err = t.HostStorage.Remove(ctx).ByKey("page:%s", prev).Do()
+		if err != nil {
+			return
+		}
+
+		err = t.HostStorage.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+		if err != nil {
+			return
+		}
+
+		err = t.unindexPage(ctx, prev)
+		if err != nil {
+			return
+		}
+
+		err = t.indexPage(ctx, page)
+		if err != nil {
+			return
+		}
+
+		t.invalidate(prev)
+
+		err = t.moveRevisions(ctx, prev, page.Name)
+		if err != nil {
+			return
+		}
+
+		err = t.moveLocales(ctx, prev, page.Name, authorId)
+		if err != nil {
+			return
+		}
+
+		err = t.addRedirect(ctx, prev, page.Name, authorId)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// renamed page leaves the permanent redirect, existing redirects to the old name are moved to the new one
+func (t *implPageService) addRedirect(ctx context.Context, from, to, authorId string) error {
+
+	// the page is there now
+	err := t.HostStorage.Remove(ctx).ByKey("page-redirect:%s", to).Do()
+	if err != nil {
+		return err
+	}
+
+	var list []*pb.PageRedirectEntity
+	err = t.EnumRedirects(ctx, func(redirect *pb.PageRedirectEntity) bool {
+		if redirect.To == from {
+			list = append(list, redirect)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, redirect := range list {
+		redirect.To = to
+		err = t.HostStorage.Set(ctx).ByKey("page-redirect:%s", redirect.From).Proto(redirect)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.setRedirect(ctx, &pb.PageRedirectEntity{
+		From:      from,
+		To:        to,
+		CreatedBy: authorId,
+	})
+}
+
+func (t *implPageService) setRedirect(ctx context.Context, redirect *pb.PageRedirectEntity) error {
+
+	err := t.checkRedirectLoop(ctx, redirect.From, redirect.To)
+	if err != nil {
+		return err
+	}
+
+	redirect.CreTimestamp = time.Now().Unix()
+	return t.HostStorage.Set(ctx).ByKey("page-redirect:%s", redirect.From).Proto(redirect)
+}
+
+func (t *implPageService) checkRedirectLoop(ctx context.Context, from, to string) error {
+
+	visited := map[string]bool{from: true}
+	for cur, hops := to, 0; ; hops++ {
+
+		if visited[cur] {
+			return errors.Errorf("nowrap: redirect from '%s' to '%s' makes a loop through '%s'", from, to, cur)
+		}
+		if hops >= maxRedirectHops {
+			return errors.Errorf("nowrap: redirect chain from '%s' is longer than %d", from, maxRedirectHops)
+		}
+		visited[cur] = true
+
+		next := new(pb.PageRedirectEntity)
+		err := t.HostStorage.Get(ctx).ByKey("page-redirect:%s", cur).ToProto(next)
+		if err != nil {
+			return err
+		}
+		if next.To == "" {
+			return nil
+		}
+		cur = next.To
+	}
+}
+
+func (t *implPageService) ResolveRedirect(ctx context.Context, name string) (*pb.PageRedirectEntity, error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return nil, errors.New("page name is empty")
+	}
+
+	resolved := &pb.PageRedirectEntity{From: name}
+	for cur, hops := name, 0; ; hops++ {
+
+		redirect := new(pb.PageRedirectEntity)
+		err := t.HostStorage.Get(ctx).ByKey("page-redirect:%s", cur).ToProto(redirect)
+		if err != nil {
+			return nil, err
+		}
+		if redirect.To == "" {
+			break
+		}
+		if hops == maxRedirectHops {
+			return nil, errors.Errorf("redirect chain from '%s' is longer than %d", name, maxRedirectHops)
+		}
+
+		// chain is temporary if any of redirects is temporary
+		resolved.To = redirect.To
+		resolved.Temporary = resolved.Temporary || redirect.Temporary
+		cur = redirect.To
+	}
+
+	if resolved.To == "" {
+		return nil, nil
+	}
+	return resolved, nil
+}
+
+func (t *implPageService) SaveRedirect(ctx context.Context, redirect *pb.PageRedirectEntity) (err error) {
+
+	redirect.From = utils.NormalizePageId(redirect.From)
+	redirect.To = utils.NormalizePageId(redirect.To)
+	if redirect.From == "" || redirect.To == "" {
+		return errors.New("nowrap: redirect path is empty")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	page := new(pb.PageEntity)
+	err = t.HostStorage.Get(ctx).ByKey("page:%s", redirect.From).ToProto(page)
+	if err != nil {
+		return
+	}
+	if page.Name != "" {
+		err = errors.Errorf("nowrap: page '%s' exist, redirect would be ignored", redirect.From)
+		return
+	}
+
+	err = t.setRedirect(ctx, redirect)
+	return
+}
+
+func (t *implPageService) RemoveRedirect(ctx context.Context, from string) error {
+
+	from = utils.NormalizePageId(from)
+	if from == "" {
+		return errors.New("redirect path is empty")
+	}
+
+	return t.HostStorage.Remove(ctx).ByKey("page-redirect:%s", from).Do()
+}
+
+func (t *implPageService) EnumRedirects(ctx context.Context, cb func(redirect *pb.PageRedirectEntity) bool) error {
+
+	return t.HostStorage.Enumerate(ctx).
+		ByPrefix("page-redirect:").
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageRedirectEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageRedirectEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implPageService) ReorderPages(ctx context.Context, parent string, names []string) (err error) {
+
+	parent = utils.NormalizePageId(parent)
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	for i, name := range names {
+
+		name = utils.NormalizePageId(name)
+		if utils.ParentPageId(name) != parent {
+			err = errors.Errorf("nowrap: page '%s' is not a child of '%s'", name, parent)
+			return
+		}
+
+		page := new(pb.PageEntity)
+		err = t.HostStorage.Get(ctx).ByKey("page:%s", name).ToProto(page)
+		if err != nil {
+			return
+		}
+		if page.Name == "" {
+			err = errors.Errorf("nowrap: page '%s' not found", name)
+			return
+		}
+
+		// the version stays, because UpdatePage keeps the stored order and the open editors are not stale
+		page.SortOrder = int32(i + 1)
+		err = t.HostStorage.Set(ctx).ByKey("page:%s", name).Proto(page)
+		if err != nil {
+			return
+		}
+		t.invalidate(name)
+	}
+
+	return
+}
+
+func (t *implPageService) Menu(ctx context.Context, now int64, viewer *sprint.AuthorizedUser) ([]*pb.MenuItem, error) {
+
+	var root []*pb.MenuItem
+	nodes := make(map[string]*pb.MenuItem)
+	sortOrder := make(map[*pb.MenuItem]int32)
+
+	// keys are sorted, so parents come before their children
+	err := t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		if !IsPagePublic(page, now) || !CanViewPage(page, viewer) {
+			return true
+		}
+
+		title := page.Title
+		if title == "" {
+			title = page.Name[strings.LastIndexByte(page.Name, '/')+1:]
+		}
+
+		item := &pb.MenuItem{Name: page.Name, Title: title}
+		nodes[page.Name] = item
+		sortOrder[item] = page.SortOrder
+
+		// children of hidden pages go to the nearest visible ancestor
+		for parent := utils.ParentPageId(page.Name); ; parent = utils.ParentPageId(parent) {
+			if parent == "" {
+				root = append(root, item)
+				break
+			}
+			if p, ok := nodes[parent]; ok {
+				p.Children = append(p.Children, item)
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortMenu(root, sortOrder)
+	return root, nil
+}
+
+// ordered pages go first, the rest by name
+func sortMenu(items []*pb.MenuItem, sortOrder map[*pb.MenuItem]int32) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := sortOrder[items[i]], sortOrder[items[j]]
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		if a != b {
+			return a < b
+		}
+		return items[i].Name < items[j].Name
+	})
+	for _, item := range items {
+		sortMenu(item.Children, sortOrder)
+	}
+}
+
+func (t *implPageService) PublishScheduled(ctx context.Context, now int64) (int, error) {
+
+	var names []string
+	err := t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		if scheduledStatus(page, now) != page.Status {
+			names = append(names, page.Name)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+	for _, name := range names {
+		changed, err := t.flipStatus(ctx, name, now)
+		if err != nil {
+			return cnt, err
+		}
+		if changed {
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+func (t *implPageService) flipStatus(ctx context.Context, name string, now int64) (changed bool, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	page := new(pb.PageEntity)
+	err = t.HostStorage.Get(ctx).ByKey("page:%s", name).ToProto(page)
+	if err != nil || page.Name == "" {
+		return
+	}
+
+	status := scheduledStatus(page, now)
+	if status == page.Status {
+		return
+	}
+
+	// the version stays, the editor opened before the flip saves the schedule that is already applied
+	page.Status = status
+	err = t.HostStorage.Set(ctx).ByKey("page:%s", name).Proto(page)
+	return err == nil, err
+}
+
+// status of the page at the given time according to publish_at and unpublish_at
+func scheduledStatus(page *pb.PageEntity, now int64) pb.PageStatus {
+	status := page.Status
+	if status == pb.PageStatus_SCHEDULED && page.PublishAt > 0 && now >= page.PublishAt {
+		status = pb.PageStatus_PUBLISHED
+	}
+	if status == pb.PageStatus_PUBLISHED && page.UnpublishAt > 0 && now >= page.UnpublishAt {
+		status = pb.PageStatus_ARCHIVED
+	}
+	return status
+}
+
+// checks the time as well, because the scheduler flips the status with the delay
+func IsPagePublic(page *pb.PageEntity, now int64) bool {
+	return scheduledStatus(page, now) == pb.PageStatus_PUBLISHED
+}
+
+// visibility of the default page, the viewer is nil for guests, web admins see all pages
+func CanViewPage(page *pb.PageEntity, viewer *sprint.AuthorizedUser) bool {
+	switch page.Visibility {
+	case pb.PageVisibility_PUBLIC:
+		return true
+	case pb.PageVisibility_AUTHENTICATED:
+		return viewer != nil
+	}
+	if viewer == nil {
+		return false
+	}
+	if viewer.Roles["WEB_ADMIN"] {
+		return true
+	}
+	for _, role := range page.Roles {
+		if viewer.Roles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// empty visibility in the request keeps the current one, roles are kept only for the ROLES visibility
+func applyVisibility(entity *pb.PageEntity, req *pb.AdminPage) error {
+
+	if strings.TrimSpace(req.Visibility) == "" {
+		return nil
+	}
+
+	value, ok := pb.PageVisibility_value[strings.ToUpper(strings.TrimSpace(req.Visibility))]
+	if !ok {
+		return errors.Errorf("nowrap: invalid page visibility '%s'", req.Visibility)
+	}
+	entity.Visibility = pb.PageVisibility(value)
+
+	entity.Roles = nil
+	if entity.Visibility != pb.PageVisibility_ROLES {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, role := range req.Roles {
+		// comma separated input is accepted as well
+		for _, r := range strings.Split(role, ",") {
+			r = strings.ToUpper(strings.TrimSpace(r))
+			if r != "" && !seen[r] {
+				seen[r] = true
+				entity.Roles = append(entity.Roles, r)
+			}
+		}
+	}
+	if len(entity.Roles) == 0 {
+		return errors.New("nowrap: page visible by roles needs at least one role")
+	}
+	return nil
+}
+
+// empty status in the request keeps the current one
+func (t *implPageService) applyStatus(entity *pb.PageEntity, req *pb.AdminPage) error {
+
+	if strings.TrimSpace(req.Status) == "" {
+		return nil
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(req.Status)) {
+	case "DRAFT":
+		entity.Status = pb.PageStatus_DRAFT
+	case "SCHEDULED":
+		entity.Status = pb.PageStatus_SCHEDULED
+	case "PUBLISHED":
+		entity.Status = pb.PageStatus_PUBLISHED
+	case "ARCHIVED":
+		entity.Status = pb.PageStatus_ARCHIVED
+	default:
+		return errors.Errorf("nowrap: invalid page status '%s'", req.Status)
+	}
+
+	if entity.Status == pb.PageStatus_SCHEDULED && req.PublishAt <= 0 {
+		return errors.New("nowrap: scheduled page needs publish time")
+	}
+
+	if req.UnpublishAt > 0 && req.UnpublishAt <= req.PublishAt {
+		return errors.New("nowrap: unpublish time must be after publish time")
+	}
+
+	entity.PublishAt = req.PublishAt
+	entity.UnpublishAt = req.UnpublishAt
+	return nil
+}
+
+// metadata is replaced by the request like the title
+func applyMeta(entity *pb.PageEntity, req *pb.AdminPage) error {
+
+	for _, u := range []string{req.OgImage, req.CanonicalUrl} {
+		if !isMetaUrl(strings.TrimSpace(u)) {
+			return errors.Errorf("nowrap: invalid url '%s', absolute http(s) or site relative url is expected", u)
+		}
+	}
+
+	entity.Description = strings.TrimSpace(whiteSpaces.ReplaceAllString(req.Description, " "))
+	entity.OgTitle = strings.TrimSpace(req.OgTitle)
+	entity.OgImage = strings.TrimSpace(req.OgImage)
+	entity.CanonicalUrl = strings.TrimSpace(req.CanonicalUrl)
+	entity.Noindex = req.Noindex
+	entity.Layout = utils.NormalizeIdentityField(req.Layout)
+	// 'Release Notes' is the release-notes category
+	entity.Category = utils.NormalizeIdentityField(whiteSpaces.ReplaceAllString(strings.TrimSpace(req.Category), "-"))
+
+	entity.Keywords = nil
+	seen := make(map[string]bool)
+	for _, keyword := range req.Keywords {
+		// comma separated input is accepted as well
+		for _, k := range strings.Split(keyword, ",") {
+			k = strings.TrimSpace(k)
+			if k != "" && !seen[strings.ToLower(k)] {
+				seen[strings.ToLower(k)] = true
+				entity.Keywords = append(entity.Keywords, k)
+			}
+		}
+	}
+
+	entity.Tags = nil
+	seen = make(map[string]bool)
+	for _, tag := range req.Tags {
+		for _, k := range strings.Split(tag, ",") {
+			k = strings.ToLower(strings.TrimSpace(whiteSpaces.ReplaceAllString(k, " ")))
+			if k != "" && !seen[k] {
+				seen[k] = true
+				entity.Tags = append(entity.Tags, k)
+			}
+		}
+	}
+	return nil
+}
+
+func isMetaUrl(s string) bool {
+	if s == "" {
+		return true
+	}
+	if strings.HasPrefix(s, "/") {
+		return !strings.HasPrefix(s, "//")
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (t *implPageService) parseContentType(ct string) (pb.ContentType, error) {
+	if t.RenderService != nil {
+		return t.RenderService.ParseContentType(ct)
+	}
+	value, ok := pb.ContentType_value[strings.ToUpper(strings.TrimSpace(ct))]
+	if !ok {
+		return 0, errors.Errorf("invalid content type '%s'", ct)
+	}
+	return pb.ContentType(value), nil
+}
+
+// malformed content of structured types is rejected on save
+func (t *implPageService) validateContent(contentType pb.ContentType, content string) error {
+	if t.RenderService != nil {
+		if _, _, err := t.RenderService.Render(contentType, content); err != nil {
+			return errors.Errorf("nowrap: %v", err)
+		}
 	}
-	return contentType, nil
+	return nil
 }
 
@@ -0,0 +1,939 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprint"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/codeallergy/template/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPageService(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"page.max-revisions": 3,
+	}}
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, properties, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyPageRevisions(t, pageService)
+	verifyLegacyPageRevision(t, pageService, hostStore)
+	verifyPageStatus(t, pageService)
+	verifyPageAuthorship(t, pageService, hostStore)
+	verifyPageMeta(t, pageService)
+
+}
+
+func verifyPageMeta(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:         "seo",
+		Title:        "Seo",
+		ContentType:  "MARKDOWN",
+		Description:  " Short\n description ",
+		Keywords:     []string{"go, cms", "Go", " "},
+		OgImage:      "/media/0123456789abcdef01234567",
+		CanonicalUrl: "https://example.com/seo",
+		Category:     "Release Notes",
+		Tags:         []string{"Go,  Release  Notes", "go"},
+	}, "u00001")
+	require.NoError(t, err)
+
+	page, err := pageService.GetPage(ctx, "seo")
+	require.NoError(t, err)
+	require.Equal(t, "Short description", page.Description)
+	require.Equal(t, []string{"go", "cms"}, page.Keywords)
+	require.Equal(t, "/media/0123456789abcdef01234567", page.OgImage)
+	require.Equal(t, "https://example.com/seo", page.CanonicalUrl)
+	require.False(t, page.Noindex)
+	require.Equal(t, "release-notes", page.Category)
+	require.Equal(t, []string{"go", "release notes"}, page.Tags)
+
+	for _, u := range []string{"javascript:alert(1)", "//evil.com/x", "ftp://example.com", "media/1"} {
+		err = pageService.UpdatePage(ctx, &pb.AdminPage{
+			Name:         "seo",
+			ContentType:  "MARKDOWN",
+			CanonicalUrl: u,
+			Version:      page.Version,
+		}, "u00001")
+		require.Error(t, err, u)
+	}
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "seo",
+		Title:       "Seo",
+		Content:     "changed",
+		ContentType: "MARKDOWN",
+		Description: "Hidden",
+		Noindex:     true,
+		Version:     page.Version,
+	}, "u00001")
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(ctx, "seo")
+	require.NoError(t, err)
+	require.Equal(t, "Hidden", page.Description)
+	require.Empty(t, page.Keywords)
+	require.Empty(t, page.CanonicalUrl)
+	require.True(t, page.Noindex)
+
+	// metadata is not restored with the content
+	require.NoError(t, pageService.RestorePageRevision(ctx, "seo", 1, "u00001"))
+
+	page, err = pageService.GetPage(ctx, "seo")
+	require.NoError(t, err)
+	require.Equal(t, "", page.Content)
+	require.Equal(t, "Hidden", page.Description)
+	require.True(t, page.Noindex)
+	require.Empty(t, page.Category)
+}
+
+func listRevisions(t *testing.T, pageService api.PageService, name string) []*pb.PageRevisionEntity {
+	var list []*pb.PageRevisionEntity
+	err := pageService.EnumPageRevisions(context.Background(), name, func(rev *pb.PageRevisionEntity) bool {
+		list = append(list, rev)
+		return true
+	})
+	require.NoError(t, err)
+	return list
+}
+
+func verifyPageRevisions(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:        "about",
+		Title:       "About",
+		Content:     "line one\nline two",
+		ContentType: "MARKDOWN",
+	}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "about",
+		Title:       "About Us",
+		Content:     "line one\nline 2\nline three",
+		ContentType: "MARKDOWN",
+		Note:        "fix typo",
+		Version:     1,
+	}, "u00002")
+	require.NoError(t, err)
+
+	list := listRevisions(t, pageService, "about")
+	require.Equal(t, 2, len(list))
+	require.Equal(t, int64(1), list[0].Revision)
+	require.Equal(t, "u00001", list[0].AuthorId)
+	require.Equal(t, int64(2), list[1].Revision)
+	require.Equal(t, "u00002", list[1].AuthorId)
+	require.Equal(t, "fix typo", list[1].Note)
+
+	diff := utils.LineDiff(list[0].Content, list[1].Content)
+	require.Equal(t, []utils.DiffLine{
+		{Op: utils.DiffEqual, Text: "line one"},
+		{Op: utils.DiffRemoved, Text: "line two"},
+		{Op: utils.DiffAdded, Text: "line 2"},
+		{Op: utils.DiffAdded, Text: "line three"},
+	}, diff)
+
+	err = pageService.RestorePageRevision(ctx, "about", 1, "u00003")
+	require.NoError(t, err)
+
+	page, err := pageService.GetPage(ctx, "about")
+	require.NoError(t, err)
+	require.Equal(t, "About", page.Title)
+	require.Equal(t, "line one\nline two", page.Content)
+
+	list = listRevisions(t, pageService, "about")
+	require.Equal(t, 3, len(list))
+	require.Equal(t, "u00003", list[2].AuthorId)
+	require.Equal(t, "restored revision 1", list[2].Note)
+
+	// rename moves the history, the oldest revision is over the limit
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "about-us",
+		Prev:        "about",
+		Title:       "About",
+		Content:     "moved",
+		ContentType: "HTML",
+		Version:     3,
+	}, "u00001")
+	require.NoError(t, err)
+
+	require.Equal(t, 0, len(listRevisions(t, pageService, "about")))
+
+	list = listRevisions(t, pageService, "about-us")
+	require.Equal(t, 3, len(list))
+	require.Equal(t, int64(2), list[0].Revision)
+	require.Equal(t, int64(4), list[2].Revision)
+	require.Equal(t, "about-us", list[2].Name)
+
+	_, err = pageService.GetPageRevision(ctx, "about-us", 1)
+	require.Equal(t, service.ErrPageRevisionNotFound, err)
+
+	// revisions survive removal
+	err = pageService.RemovePage(ctx, "about-us")
+	require.NoError(t, err)
+
+	err = pageService.RestorePageRevision(ctx, "about-us", 3, "u00001")
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(ctx, "about-us")
+	require.NoError(t, err)
+	require.Equal(t, "line one\nline two", page.Content)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "about-us",
+		ContentType: "TEXT",
+		Version:     1,
+	}, "u00001")
+	require.Error(t, err)
+
+}
+
+func verifyLegacyPageRevision(t *testing.T, pageService api.PageService, hostStore store.DataStore) {
+
+	ctx := context.Background()
+
+	err := hostStore.Set(ctx).ByKey("page:%s", "legacy").Proto(&pb.PageEntity{
+		Name:         "legacy",
+		Title:        "Legacy",
+		Content:      "old content",
+		CreTimestamp: 1600000000,
+	})
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "legacy",
+		Title:       "Legacy",
+		Content:     "new content",
+		ContentType: "MARKDOWN",
+	}, "u00001")
+	require.NoError(t, err)
+
+	list := listRevisions(t, pageService, "legacy")
+	require.Equal(t, 2, len(list))
+	require.Equal(t, "old content", list[0].Content)
+	require.Equal(t, int64(1600000000), list[0].CreTimestamp)
+	require.Equal(t, "new content", list[1].Content)
+
+}
+
+func verifyPageStatus(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:        "draft",
+		ContentType: "MARKDOWN",
+	}, "u00001")
+	require.NoError(t, err)
+
+	page, err := pageService.GetPage(ctx, "draft")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageStatus_DRAFT, page.Status)
+	require.False(t, service.IsPagePublic(page, now))
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:        "news",
+		ContentType: "MARKDOWN",
+		Status:      "SCHEDULED",
+	}, "u00001")
+	require.Error(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:        "news",
+		ContentType: "MARKDOWN",
+		Status:      "SCHEDULED",
+		PublishAt:   now + 100,
+		UnpublishAt: now + 200,
+	}, "u00001")
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(ctx, "news")
+	require.NoError(t, err)
+	require.False(t, service.IsPagePublic(page, now))
+	require.True(t, service.IsPagePublic(page, now + 100))
+	require.False(t, service.IsPagePublic(page, now + 200))
+
+	// update without status keeps the schedule
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "news",
+		Content:     "updated",
+		ContentType: "MARKDOWN",
+		Version:     1,
+	}, "u00001")
+	require.NoError(t, err)
+
+	cnt, err := pageService.PublishScheduled(ctx, now + 150)
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	page, err = pageService.GetPage(ctx, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageStatus_PUBLISHED, page.Status)
+	require.Equal(t, "updated", page.Content)
+	require.Equal(t, int64(2), page.Version)
+
+	// edit opened before the scheduler published the page is not stale
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "news",
+		Content:     "edited",
+		ContentType: "MARKDOWN",
+		Version:     2,
+	}, "u00001")
+	require.NoError(t, err)
+
+	cnt, err = pageService.PublishScheduled(ctx, now + 150)
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	cnt, err = pageService.PublishScheduled(ctx, now + 250)
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	page, err = pageService.GetPage(ctx, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageStatus_ARCHIVED, page.Status)
+
+}
+
+func verifyPageAuthorship(t *testing.T, pageService api.PageService, hostStore store.DataStore) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{
+		Name:        "authors",
+		ContentType: "MARKDOWN",
+	}, "u00001")
+	require.NoError(t, err)
+
+	created, err := pageService.GetPage(ctx, "authors")
+	require.NoError(t, err)
+	require.Equal(t, "u00001", created.CreatedBy)
+	require.Equal(t, "u00001", created.UpdatedBy)
+	require.Equal(t, created.CreTimestamp, created.UpdTimestamp)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "authors",
+		Content:     "changed",
+		ContentType: "MARKDOWN",
+		Version:     created.Version,
+	}, "u00002")
+	require.NoError(t, err)
+
+	// second admin edits the same version
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "authors",
+		Content:     "stale",
+		ContentType: "MARKDOWN",
+		Version:     created.Version,
+	}, "u00003")
+	require.True(t, errors.Is(err, service.ErrVersionConflict))
+
+	updated, err := pageService.GetPage(ctx, "authors")
+	require.NoError(t, err)
+	require.Equal(t, created.CreTimestamp, updated.CreTimestamp)
+	require.Equal(t, "u00001", updated.CreatedBy)
+	require.Equal(t, "u00002", updated.UpdatedBy)
+	require.Equal(t, created.Version + 1, updated.Version)
+	require.Equal(t, "changed", updated.Content)
+
+	// record saved before upd_timestamp has the last update time in cre_timestamp
+	err = hostStore.Set(ctx).ByKey("page:%s", "old").Proto(&pb.PageEntity{
+		Name:         "old",
+		CreTimestamp: 1700000000,
+	})
+	require.NoError(t, err)
+	err = hostStore.Set(ctx).ByKey("page-revision:%s:%010d", "old", 1).Proto(&pb.PageRevisionEntity{
+		Name:         "old",
+		Revision:     1,
+		AuthorId:     "u00009",
+		CreTimestamp: 1600000000,
+	})
+	require.NoError(t, err)
+	err = hostStore.Set(ctx).ByKey("page:%s", "older").Proto(&pb.PageEntity{
+		Name:         "older",
+		CreTimestamp: 1500000000,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, pageService.(glue.InitializingBean).PostConstruct())
+
+	page, err := pageService.GetPage(ctx, "old")
+	require.NoError(t, err)
+	require.Equal(t, int64(1600000000), page.CreTimestamp)
+	require.Equal(t, int64(1700000000), page.UpdTimestamp)
+	require.Equal(t, "u00009", page.CreatedBy)
+	require.Equal(t, "u00009", page.UpdatedBy)
+
+	page, err = pageService.GetPage(ctx, "older")
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000000), page.CreTimestamp)
+	require.Equal(t, int64(1500000000), page.UpdTimestamp)
+
+}
+
+func TestPageTree(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyPageTree(t, pageService)
+	verifyPageRedirects(t, pageService)
+	verifyPageVisibility(t, pageService)
+
+}
+
+func menuNames(items []*pb.MenuItem) []string {
+	var list []string
+	for _, item := range items {
+		list = append(list, item.Name)
+	}
+	return list
+}
+
+func verifyPageTree(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	require.Equal(t, "docs/getting-started", utils.NormalizePageId("/Docs//Getting-Started/"))
+	require.Equal(t, "docs", utils.ParentPageId("docs/getting-started"))
+	require.Equal(t, "", utils.ParentPageId("docs"))
+
+	for _, name := range []string{"docs", "docs/getting-started", "docs/api", "docs/api/v1", "about", "hidden", "hidden/child"} {
+		status := "PUBLISHED"
+		if name == "hidden" {
+			status = "DRAFT"
+		}
+		err := pageService.CreatePage(ctx, &pb.AdminPage{
+			Name:        name,
+			Title:       name,
+			ContentType: "MARKDOWN",
+			Status:      status,
+		}, "u00001")
+		require.NoError(t, err)
+	}
+
+	now := time.Now().Unix()
+	menu, err := pageService.Menu(ctx, now, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"about", "docs", "hidden/child"}, menuNames(menu))
+	require.Equal(t, []string{"docs/api", "docs/getting-started"}, menuNames(menu[1].Children))
+	require.Equal(t, []string{"docs/api/v1"}, menuNames(menu[1].Children[0].Children))
+
+	err = pageService.ReorderPages(ctx, "docs", []string{"docs/getting-started", "docs/api"})
+	require.NoError(t, err)
+
+	// edit opened before the reorder is not stale and keeps the order
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "docs/api", Title: "API", ContentType: "MARKDOWN", Version: 1}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.ReorderPages(ctx, "", []string{"docs"})
+	require.NoError(t, err)
+
+	err = pageService.ReorderPages(ctx, "docs", []string{"about"})
+	require.Error(t, err)
+
+	menu, err = pageService.Menu(ctx, now, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs", "about", "hidden/child"}, menuNames(menu))
+	require.Equal(t, []string{"docs/getting-started", "docs/api"}, menuNames(menu[0].Children))
+
+	err = pageService.MovePage(ctx, "docs", "docs/api/docs", "u00001")
+	require.Error(t, err)
+
+	err = pageService.MovePage(ctx, "docs/api", "about", "u00001")
+	require.Error(t, err)
+
+	err = pageService.MovePage(ctx, "docs/api", "reference/api", "u00002")
+	require.NoError(t, err)
+
+	_, err = pageService.GetPage(ctx, "docs/api")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	page, err := pageService.GetPage(ctx, "reference/api/v1")
+	require.NoError(t, err)
+	require.Equal(t, "u00002", page.UpdatedBy)
+
+	redirect, err := pageService.ResolveRedirect(ctx, "docs/api/v1")
+	require.NoError(t, err)
+	require.Equal(t, "reference/api/v1", redirect.To)
+	require.False(t, redirect.Temporary)
+
+	redirect, err = pageService.ResolveRedirect(ctx, "docs")
+	require.NoError(t, err)
+	require.Nil(t, redirect)
+
+	// created and edited after the reorder
+	list := listRevisions(t, pageService, "reference/api")
+	require.Equal(t, 2, len(list))
+	require.Equal(t, "reference/api", list[0].Name)
+
+	menu, err = pageService.Menu(ctx, now, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs", "about", "hidden/child", "reference/api"}, menuNames(menu))
+	require.Equal(t, []string{"docs/getting-started"}, menuNames(menu[0].Children))
+
+	// rename in the editor moves the children as well and keeps the order
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "guide", Prev: "docs", Title: "Guide", ContentType: "MARKDOWN", Status: "PUBLISHED", Version: 1}, "u00002")
+	require.NoError(t, err)
+
+	_, err = pageService.GetPage(ctx, "docs/getting-started")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	page, err = pageService.GetPage(ctx, "guide")
+	require.NoError(t, err)
+	require.Equal(t, "Guide", page.Title)
+
+	redirect, err = pageService.ResolveRedirect(ctx, "docs/getting-started")
+	require.NoError(t, err)
+	require.Equal(t, "guide/getting-started", redirect.To)
+
+	menu, err = pageService.Menu(ctx, now, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"guide", "about", "hidden/child", "reference/api"}, menuNames(menu))
+	require.Equal(t, []string{"guide/getting-started"}, menuNames(menu[0].Children))
+
+}
+
+func verifyPageVisibility(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{Name: "members", Title: "members", ContentType: "MARKDOWN", Status: "PUBLISHED", Visibility: "authenticated"}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "staff", Title: "staff", ContentType: "MARKDOWN", Status: "PUBLISHED", Visibility: "ROLES"}, "u00001")
+	require.Error(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "staff", Title: "staff", ContentType: "MARKDOWN", Status: "PUBLISHED", Visibility: "ROLES", Roles: []string{"staff, web_user"}}, "u00001")
+	require.NoError(t, err)
+
+	staff, err := pageService.GetPage(ctx, "staff")
+	require.NoError(t, err)
+	require.Equal(t, []string{"STAFF", "WEB_USER"}, staff.Roles)
+
+	guest := (*sprint.AuthorizedUser)(nil)
+	user := &sprint.AuthorizedUser{Username: "u00002", Roles: map[string]bool{"USER": true}}
+	member := &sprint.AuthorizedUser{Username: "u00003", Roles: map[string]bool{"STAFF": true}}
+	admin := &sprint.AuthorizedUser{Username: "u00004", Roles: map[string]bool{"WEB_ADMIN": true}}
+
+	require.False(t, service.CanViewPage(staff, guest))
+	require.False(t, service.CanViewPage(staff, user))
+	require.True(t, service.CanViewPage(staff, member))
+	require.True(t, service.CanViewPage(staff, admin))
+
+	now := time.Now().Unix()
+	for viewer, expected := range map[*sprint.AuthorizedUser][]string{
+		guest:  nil,
+		user:   {"members"},
+		member: {"members", "staff"},
+	} {
+		menu, err := pageService.Menu(ctx, now, viewer)
+		require.NoError(t, err)
+		names := menuNames(menu)
+		for _, name := range []string{"members", "staff"} {
+			if contains(expected, name) {
+				require.Contains(t, names, name)
+			} else {
+				require.NotContains(t, names, name)
+			}
+		}
+	}
+
+	// empty visibility keeps the current one
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "staff", Title: "staff", ContentType: "MARKDOWN", Version: staff.Version}, "u00001")
+	require.NoError(t, err)
+
+	staff, err = pageService.GetPage(ctx, "staff")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageVisibility_ROLES, staff.Visibility)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "staff", Title: "staff", ContentType: "MARKDOWN", Version: staff.Version, Visibility: "PUBLIC"}, "u00001")
+	require.NoError(t, err)
+
+	staff, err = pageService.GetPage(ctx, "staff")
+	require.NoError(t, err)
+	require.True(t, service.CanViewPage(staff, guest))
+	require.Empty(t, staff.Roles)
+
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyPageRedirects(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{Name: "a", ContentType: "MARKDOWN"}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "b", Prev: "a", ContentType: "MARKDOWN", Version: 1}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "c", Prev: "b", ContentType: "MARKDOWN", Version: 2}, "u00001")
+	require.NoError(t, err)
+
+	// renames do not make chains
+	cnt := 0
+	err = pageService.EnumRedirects(ctx, func(redirect *pb.PageRedirectEntity) bool {
+		if redirect.From == "a" || redirect.From == "b" {
+			require.Equal(t, "c", redirect.To)
+			require.Equal(t, "u00001", redirect.CreatedBy)
+			cnt++
+		}
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, cnt)
+
+	err = pageService.SaveRedirect(ctx, &pb.PageRedirectEntity{From: "x", To: "a", Temporary: true})
+	require.NoError(t, err)
+
+	redirect, err := pageService.ResolveRedirect(ctx, "x")
+	require.NoError(t, err)
+	require.Equal(t, "c", redirect.To)
+	require.True(t, redirect.Temporary)
+
+	err = pageService.SaveRedirect(ctx, &pb.PageRedirectEntity{From: "c", To: "x"})
+	require.Error(t, err)
+
+	err = pageService.SaveRedirect(ctx, &pb.PageRedirectEntity{From: "a", To: "x"})
+	require.Error(t, err)
+
+	err = pageService.SaveRedirect(ctx, &pb.PageRedirectEntity{From: "y", To: "y"})
+	require.Error(t, err)
+
+	err = pageService.RemoveRedirect(ctx, "x")
+	require.NoError(t, err)
+
+	redirect, err = pageService.ResolveRedirect(ctx, "x")
+	require.NoError(t, err)
+	require.Nil(t, redirect)
+
+}
+
+func TestPageLocales(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"page.default-locale":  "en",
+		"page.locale-fallback": "pt-br=es",
+	}}
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, properties, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyPageLocales(t, pageService)
+
+}
+
+func verifyPageLocales(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	require.Equal(t, []string{"de-at", "fr", "en"}, utils.ParseAcceptLanguage("fr;q=0.8, de_AT, *;q=0.5, en;q=0.1, it;q=0"))
+	require.Equal(t, "en", pageService.DefaultLocale())
+	require.Equal(t, []string{"de-at", "de"}, pageService.LocaleChain([]string{"de-AT", "en", "fr"}))
+	require.Equal(t, []string{"pt-br", "es", "fr"}, pageService.LocaleChain([]string{"pt_BR", "fr"}))
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", Title: "About", ContentType: "MARKDOWN", Locale: "de"}, "u00001")
+	require.Error(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", Title: "About", ContentType: "MARKDOWN", Status: "PUBLISHED"}, "u00001")
+	require.NoError(t, err)
+
+	for _, locale := range []string{"pt-BR", "de"} {
+		err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", Title: "About " + locale, ContentType: "MARKDOWN", Locale: locale}, "u00001")
+		require.NoError(t, err)
+	}
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", ContentType: "MARKDOWN", Locale: "de"}, "u00001")
+	require.Error(t, err)
+
+	locales, err := pageService.PageLocales(ctx, "about")
+	require.NoError(t, err)
+	require.Equal(t, []string{"de", "pt-br"}, locales)
+
+	// translations are not listed as pages
+	var names []string
+	err = pageService.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		names = append(names, page.Name)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"about"}, names)
+
+	page, err := pageService.GetPageLocale(ctx, "about", "EN")
+	require.NoError(t, err)
+	require.Equal(t, "About", page.Title)
+	require.Equal(t, "", page.Locale)
+
+	page, err = pageService.GetPageLocale(ctx, "about", "de")
+	require.NoError(t, err)
+	require.Equal(t, "About de", page.Title)
+	require.Equal(t, "de", page.Locale)
+	require.Equal(t, pb.PageStatus_DRAFT, page.Status)
+
+	_, err = pageService.GetPageLocale(ctx, "about", "fr")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "about", Title: "Über", ContentType: "MARKDOWN", Locale: "de", Status: "PUBLISHED", Version: page.Version + 1}, "u00002")
+	require.True(t, errors.Is(err, service.ErrVersionConflict))
+
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{Name: "about", Title: "Über", ContentType: "MARKDOWN", Locale: "de", Status: "PUBLISHED", Version: page.Version}, "u00002")
+	require.NoError(t, err)
+
+	page, err = pageService.GetPageLocale(ctx, "about", "de")
+	require.NoError(t, err)
+	require.Equal(t, "Über", page.Title)
+	require.Equal(t, pb.PageStatus_PUBLISHED, page.Status)
+	require.Equal(t, "u00002", page.UpdatedBy)
+
+	// translations follow the page
+	err = pageService.MovePage(ctx, "about", "company/about", "u00003")
+	require.NoError(t, err)
+
+	locales, err = pageService.PageLocales(ctx, "about")
+	require.NoError(t, err)
+	require.Empty(t, locales)
+
+	page, err = pageService.GetPageLocale(ctx, "company/about", "de")
+	require.NoError(t, err)
+	require.Equal(t, "company/about", page.Name)
+	require.Equal(t, "u00003", page.UpdatedBy)
+
+	err = pageService.RemovePageLocale(ctx, "company/about", "en")
+	require.Error(t, err)
+
+	err = pageService.RemovePageLocale(ctx, "company/about", "pt-br")
+	require.NoError(t, err)
+
+	locales, err = pageService.PageLocales(ctx, "company/about")
+	require.NoError(t, err)
+	require.Equal(t, []string{"de"}, locales)
+
+	err = pageService.RemovePage(ctx, "company/about")
+	require.NoError(t, err)
+
+	_, err = pageService.GetPageLocale(ctx, "company/about", "de")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+}
+
+func TestPageSearch(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, pageService,
+		service.RenderService(),
+		service.HtmlSanitizer(),
+		service.MarkdownRenderer(),
+		service.HtmlRenderer())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyPageSearch(t, pageService)
+
+}
+
+func searchNames(t *testing.T, pageService api.PageService, query string) []string {
+	total, items, err := pageService.SearchPages(context.Background(), query, 0, 10, time.Now().Unix(), nil)
+	require.NoError(t, err)
+	require.Equal(t, total, len(items))
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func verifyPageSearch(t *testing.T, pageService api.PageService) {
+
+	ctx := context.Background()
+
+	pages := []*pb.AdminPage{
+		{Name: "running", Title: "Running Shoes", Content: "How to choose shoes for **running** on the road."},
+		{Name: "hiking", Title: "Hiking", Content: "Boots for hiking. Some people prefer running shoes on easy trails."},
+		{Name: "draft", Title: "Running Draft", Content: "Not published yet.", Status: "DRAFT"},
+		{Name: "html", Title: "Markup", Content: "<p>Tom &amp; Jerry <b>searched</b> everywhere</p><script>var hidden = 1</script>", ContentType: "HTML"},
+	}
+	for _, page := range pages {
+		if page.ContentType == "" {
+			page.ContentType = "MARKDOWN"
+		}
+		if page.Status == "" {
+			page.Status = "PUBLISHED"
+		}
+		require.NoError(t, pageService.CreatePage(ctx, page, "u00001"))
+	}
+
+	// content type without the renderer
+	err := pageService.CreatePage(ctx, &pb.AdminPage{Name: "text", Content: "text", ContentType: "PLAIN_TEXT"}, "u00001")
+	require.Error(t, err)
+
+	// title match goes first, stemming finds 'running' by 'runs', drafts are hidden
+	require.Equal(t, []string{"running", "hiking"}, searchNames(t, pageService, "runs"))
+	require.Equal(t, []string{"running", "hiking"}, searchNames(t, pageService, "shoe"))
+	require.Equal(t, []string{"hiking"}, searchNames(t, pageService, "hiking boots"))
+	require.Equal(t, []string{"html"}, searchNames(t, pageService, "SEARCHING"))
+	require.Nil(t, searchNames(t, pageService, "hidden"))
+	require.Nil(t, searchNames(t, pageService, "the"))
+
+	_, items, err := pageService.SearchPages(ctx, "jerry", 0, 10, time.Now().Unix(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items))
+	require.Equal(t, "Tom &amp; <mark>Jerry</mark> searched everywhere", items[0].Snippet)
+
+	_, items, err = pageService.SearchPages(ctx, "road", 0, 10, time.Now().Unix(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(items))
+	require.Equal(t, "How to choose shoes for running on the <mark>road</mark>.", items[0].Snippet)
+
+	// index follows updates, renames and removals
+	err = pageService.UpdatePage(ctx, &pb.AdminPage{
+		Name:        "trail",
+		Prev:        "hiking",
+		Title:       "Trail",
+		Content:     "Boots for mountains.",
+		ContentType: "MARKDOWN",
+		Version:     1,
+	}, "u00001")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"running"}, searchNames(t, pageService, "running"))
+	require.Equal(t, []string{"trail"}, searchNames(t, pageService, "mountain"))
+
+	require.NoError(t, pageService.MovePage(ctx, "trail", "outdoor/trail", "u00001"))
+	require.Equal(t, []string{"outdoor/trail"}, searchNames(t, pageService, "mountain"))
+
+	require.NoError(t, pageService.RemovePage(ctx, "outdoor/trail"))
+	require.Nil(t, searchNames(t, pageService, "mountain"))
+
+	cnt, err := pageService.RebuildIndex(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, cnt)
+	require.Equal(t, []string{"running"}, searchNames(t, pageService, "running"))
+
+}
@@ -0,0 +1,522 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+	"time"
+)
+
+const frontMatterDelimiter = "---\n"
+
+// diff of larger files shows all lines as removed and added
+const maxDiffCells = 1000000
+
+var pageFileExtensions = map[pb.ContentType]string{
+	pb.ContentType_MARKDOWN:    "md",
+	pb.ContentType_HTML:        "html",
+	pb.ContentType_PLAIN_TEXT:  "txt",
+	pb.ContentType_JSON_BLOCKS: "json",
+}
+
+// header of the page file, timestamps are RFC 3339
+type pageFrontMatter struct {
+	Title         string    `yaml:"title"`
+	ContentType   string    `yaml:"content_type,omitempty"`
+	Status        string    `yaml:"status,omitempty"`
+	PublishAt     string    `yaml:"publish_at,omitempty"`
+	UnpublishAt   string    `yaml:"unpublish_at,omitempty"`
+	Description   string    `yaml:"description,omitempty"`
+	Keywords      []string  `yaml:"keywords,omitempty"`
+	OgTitle       string    `yaml:"og_title,omitempty"`
+	OgImage       string    `yaml:"og_image,omitempty"`
+	CanonicalUrl  string    `yaml:"canonical_url,omitempty"`
+	Noindex       bool      `yaml:"noindex,omitempty"`
+	Layout        string    `yaml:"layout,omitempty"`
+	Visibility    string    `yaml:"visibility,omitempty"`
+	Roles         []string  `yaml:"roles,omitempty"`
+	Category      string    `yaml:"category,omitempty"`
+	Tags          []string  `yaml:"tags,omitempty"`
+}
+
+// parsed page file, text is the normalized content of the file used to detect changes
+type pageFile struct {
+	path    string
+	name    string
+	locale  string
+	page    *pb.AdminPage
+	text    string
+}
+
+// key of the page or translation, translations go right after the page in the sorted order
+func (f *pageFile) key() string {
+	if f.locale == "" {
+		return f.name
+	}
+	return f.name + ":" + f.locale
+}
+
+type implPageSyncService struct {
+	PageService           api.PageService              `inject`
+	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+}
+
+func PageSyncService() api.PageSyncService {
+	return &implPageSyncService{}
+}
+
+func (t *implPageSyncService) ExportPages(ctx context.Context) ([]*pb.PageFile, error) {
+
+	current, err := t.currentFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*pb.PageFile
+	for _, file := range current {
+		list = append(list, &pb.PageFile{Path: file.path, Content: file.text})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	return list, nil
+}
+
+// the plan and all changes are in one transaction, so the failed import changes nothing
+func (t *implPageSyncService) ImportPages(ctx context.Context, files []*pb.PageFile, dryRun bool, authorId string) (_ []*pb.PageChange, err error) {
+
+	desired := make(map[string]*pageFile)
+	for _, f := range files {
+		file, err := t.parsePageFile(f.Path, f.Content)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := desired[file.key()]; ok {
+			return nil, errors.Errorf("nowrap: files '%s' and '%s' have the same page", prev.path, file.path)
+		}
+		desired[file.key()] = file
+	}
+
+	for _, file := range desired {
+		if _, ok := desired[file.name]; !ok {
+			return nil, errors.Errorf("nowrap: translation '%s' has no page in the default locale", file.path)
+		}
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, dryRun)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	current, err := t.currentFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*pb.PageChange
+	var apply []func() error
+
+	for _, key := range sortedKeys(desired) {
+		file := desired[key]
+		prev, ok := current[key]
+		switch {
+		case !ok:
+			changes = append(changes, &pb.PageChange{Path: file.path, Action: "CREATE", Diff: lineDiff("", file.text)})
+			apply = append(apply, func() error {
+				return t.PageService.CreatePage(ctx, file.page, authorId)
+			})
+		case prev.text != file.text:
+			changes = append(changes, &pb.PageChange{Path: file.path, Action: "UPDATE", Diff: lineDiff(prev.text, file.text)})
+			file.page.Version = prev.page.Version
+			apply = append(apply, func() error {
+				return t.PageService.UpdatePage(ctx, file.page, authorId)
+			})
+		}
+	}
+
+	// translations are removed before the page
+	keys := sortedKeys(current)
+	for i := len(keys) - 1; i >= 0; i-- {
+		file := current[keys[i]]
+		if _, ok := desired[keys[i]]; ok {
+			continue
+		}
+		changes = append(changes, &pb.PageChange{Path: file.path, Action: "DELETE", Diff: lineDiff(file.text, "")})
+		apply = append(apply, func() error {
+			if file.locale != "" {
+				return t.PageService.RemovePageLocale(ctx, file.name, file.locale)
+			}
+			return t.PageService.RemovePage(ctx, file.name)
+		})
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	for i, fn := range apply {
+		if err := fn(); err != nil {
+			return nil, fileError(err, changes[i].Path)
+		}
+	}
+	return changes, nil
+}
+
+// pages and translations by key
+func (t *implPageSyncService) currentFiles(ctx context.Context) (map[string]*pageFile, error) {
+
+	var pages []*pb.PageEntity
+	err := t.PageService.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*pageFile)
+	add := func(page *pb.PageEntity) {
+		file := &pageFile{
+			path:   pageFilePath(page),
+			name:   page.Name,
+			locale: page.Locale,
+			page:   &pb.AdminPage{Name: page.Name, Locale: page.Locale, Version: page.Version},
+			text:   encodePageFile(page),
+		}
+		files[file.key()] = file
+	}
+
+	for _, page := range pages {
+		add(page)
+
+		locales, err := t.PageService.PageLocales(ctx, page.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, locale := range locales {
+			translation, err := t.PageService.GetPageLocale(ctx, page.Name, locale)
+			if err != nil {
+				return nil, err
+			}
+			add(translation)
+		}
+	}
+	return files, nil
+}
+
+func sortedKeys(files map[string]*pageFile) []string {
+	var keys []string
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pageFilePath(page *pb.PageEntity) string {
+	ext, ok := pageFileExtensions[page.ContentType]
+	if !ok {
+		ext = "txt"
+	}
+	if page.Locale != "" {
+		return fmt.Sprintf("%s.%s.%s", page.Name, page.Locale, ext)
+	}
+	return fmt.Sprintf("%s.%s", page.Name, ext)
+}
+
+func encodePageFile(page *pb.PageEntity) string {
+
+	fm := &pageFrontMatter{
+		Title:        page.Title,
+		ContentType:  page.ContentType.String(),
+		Status:       page.Status.String(),
+		PublishAt:    formatPageTime(page.PublishAt),
+		UnpublishAt:  formatPageTime(page.UnpublishAt),
+		Description:  page.Description,
+		Keywords:     page.Keywords,
+		OgTitle:      page.OgTitle,
+		OgImage:      page.OgImage,
+		CanonicalUrl: page.CanonicalUrl,
+		Noindex:      page.Noindex,
+		Layout:       page.Layout,
+		Roles:        page.Roles,
+		Category:     page.Category,
+		Tags:         page.Tags,
+	}
+	if page.Visibility != pb.PageVisibility_PUBLIC {
+		fm.Visibility = page.Visibility.String()
+	}
+
+	header, err := yaml.Marshal(fm)
+	if err != nil {
+		// plain struct of strings always marshals
+		panic(err)
+	}
+	return frontMatterDelimiter + string(header) + frontMatterDelimiter + page.Content
+}
+
+// path is like docs/intro.md, or docs/intro.de.md for the translation, page names have no dots
+func (t *implPageSyncService) parsePageFile(path, text string) (*pageFile, error) {
+
+	path = strings.ReplaceAll(path, "\\", "/")
+	for strings.HasPrefix(path, "./") {
+		path = path[2:]
+	}
+	path = strings.TrimLeft(path, "/")
+	dir, base := "", path
+	if i := strings.LastIndexByte(path, '/'); i != -1 {
+		dir, base = path[:i+1], path[i+1:]
+	}
+
+	parts := strings.Split(base, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errors.Errorf("nowrap: file '%s' must be named like 'page.md' or 'page.de.md'", path)
+	}
+
+	file := &pageFile{
+		path: path,
+		name: dir + parts[0],
+	}
+	if file.name != utils.NormalizePageId(file.name) {
+		return nil, errors.Errorf("nowrap: file '%s' has invalid page name, only lower case letters, digits, '-' and '_' are allowed", path)
+	}
+
+	var contentType pb.ContentType
+	found := false
+	for ct, ext := range pageFileExtensions {
+		if ext == parts[len(parts)-1] {
+			contentType, found = ct, true
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("nowrap: file '%s' has unknown extension, expected md, html, txt or json", path)
+	}
+
+	if len(parts) == 3 {
+		file.locale = parts[1]
+		if file.locale != utils.NormalizeLocale(file.locale) {
+			return nil, errors.Errorf("nowrap: file '%s' has invalid locale", path)
+		}
+		if file.locale == t.PageService.DefaultLocale() {
+			return nil, errors.Errorf("nowrap: file '%s' is in the default locale, name it '%s%s.%s'", path, dir, parts[0], parts[2])
+		}
+	}
+
+	fm, content, err := parseFrontMatter(strings.ReplaceAll(text, "\r\n", "\n"))
+	if err != nil {
+		return nil, errors.Errorf("nowrap: file '%s' has invalid front matter, %v", path, err)
+	}
+
+	if fm.ContentType != "" {
+		value, ok := pb.ContentType_value[strings.ToUpper(strings.TrimSpace(fm.ContentType))]
+		if !ok {
+			return nil, errors.Errorf("nowrap: file '%s' has invalid content type '%s'", path, fm.ContentType)
+		}
+		contentType = pb.ContentType(value)
+	}
+
+	status := pb.PageStatus_DRAFT
+	if fm.Status != "" {
+		value, ok := pb.PageStatus_value[strings.ToUpper(strings.TrimSpace(fm.Status))]
+		if !ok {
+			return nil, errors.Errorf("nowrap: file '%s' has invalid status '%s'", path, fm.Status)
+		}
+		status = pb.PageStatus(value)
+	}
+
+	publishAt, err := parsePageTime(fm.PublishAt)
+	if err != nil {
+		return nil, errors.Errorf("nowrap: file '%s' has invalid publish_at, %v", path, err)
+	}
+	unpublishAt, err := parsePageTime(fm.UnpublishAt)
+	if err != nil {
+		return nil, errors.Errorf("nowrap: file '%s' has invalid unpublish_at, %v", path, err)
+	}
+
+	// missing visibility makes the page public, translations follow the default page
+	visibility := fm.Visibility
+	if file.locale != "" {
+		if visibility != "" || len(fm.Roles) > 0 {
+			return nil, errors.Errorf("nowrap: file '%s' is a translation, visibility is taken from the default page", path)
+		}
+	} else if visibility == "" {
+		visibility = pb.PageVisibility_PUBLIC.String()
+	}
+
+	file.page = &pb.AdminPage{
+		Name:         file.name,
+		Locale:       file.locale,
+		Title:        fm.Title,
+		Content:      content,
+		ContentType:  contentType.String(),
+		Status:       status.String(),
+		PublishAt:    publishAt,
+		UnpublishAt:  unpublishAt,
+		Note:         "import",
+		Description:  fm.Description,
+		Keywords:     fm.Keywords,
+		OgTitle:      fm.OgTitle,
+		OgImage:      fm.OgImage,
+		CanonicalUrl: fm.CanonicalUrl,
+		Noindex:      fm.Noindex,
+		Layout:       fm.Layout,
+		Visibility:   visibility,
+		Roles:        fm.Roles,
+		Category:     fm.Category,
+		Tags:         fm.Tags,
+	}
+
+	// same normalization as on save, so unchanged files are not updated
+	entity := &pb.PageEntity{
+		Name:        file.name,
+		Locale:      file.locale,
+		Title:       fm.Title,
+		Content:     content,
+		ContentType: contentType,
+		Status:      status,
+		PublishAt:   publishAt,
+		UnpublishAt: unpublishAt,
+	}
+	if err := applyMeta(entity, file.page); err != nil {
+		return nil, fileError(err, path)
+	}
+	if err := applyVisibility(entity, file.page); err != nil {
+		return nil, fileError(err, path)
+	}
+	file.text = encodePageFile(entity)
+
+	return file, nil
+}
+
+// keeps user visible errors visible
+func fileError(err error, path string) error {
+	if msg := err.Error(); strings.HasPrefix(msg, "nowrap:") {
+		return errors.Errorf("nowrap: file '%s', %s", path, strings.TrimSpace(strings.TrimPrefix(msg, "nowrap:")))
+	}
+	return errors.Wrapf(err, "file '%s'", path)
+}
+
+// files without the front matter have only the content
+func parseFrontMatter(text string) (*pageFrontMatter, string, error) {
+
+	fm := new(pageFrontMatter)
+	if !strings.HasPrefix(text, frontMatterDelimiter) {
+		return fm, text, nil
+	}
+
+	rest := text[len(frontMatterDelimiter):]
+	var header, content string
+	if strings.HasPrefix(rest, frontMatterDelimiter) {
+		content = rest[len(frontMatterDelimiter):]
+	} else {
+		i := strings.Index(rest, "\n"+frontMatterDelimiter)
+		if i == -1 {
+			return nil, "", errors.New("closing '---' is not found")
+		}
+		header, content = rest[:i+1], rest[i+1+len(frontMatterDelimiter):]
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(header))
+	dec.KnownFields(true)
+	if err := dec.Decode(fm); err != nil && header != "" {
+		return nil, "", err
+	}
+	return fm, content, nil
+}
+
+func formatPageTime(unix int64) string {
+	if unix <= 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+func parsePageTime(s string) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// changed lines prefixed by '-' and '+', common lines are skipped
+func lineDiff(a, b string) string {
+
+	x, y := splitLines(a), splitLines(b)
+
+	var out bytes.Buffer
+	if len(x)*len(y) > maxDiffCells {
+		for _, line := range x {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range y {
+			out.WriteString("+" + line + "\n")
+		}
+		return out.String()
+	}
+
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
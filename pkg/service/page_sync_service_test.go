@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestPageSyncService(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+	pageSyncService := service.PageSyncService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, pageService, pageSyncService,
+		service.HtmlSanitizer(),
+		service.RenderService(),
+		service.MarkdownRenderer(),
+		service.HtmlRenderer(),
+		service.PlainTextRenderer(),
+		service.JsonBlocksRenderer())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyPageSync(t, pageService, pageSyncService)
+
+}
+
+const aboutFile = `---
+title: About
+content_type: MARKDOWN
+status: PUBLISHED
+keywords:
+    - company
+---
+Hello
+`
+
+func verifyPageSync(t *testing.T, pageService api.PageService, pageSyncService api.PageSyncService) {
+
+	ctx := context.Background()
+
+	err := pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", Title: "About", Content: "Hello\n", ContentType: "MARKDOWN", Status: "PUBLISHED", Keywords: []string{"company"}}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "about", Title: "Über", Content: "Hallo\n", ContentType: "MARKDOWN", Locale: "de"}, "u00001")
+	require.NoError(t, err)
+
+	err = pageService.CreatePage(ctx, &pb.AdminPage{Name: "docs/intro", Title: "Intro", Content: "<p>Intro</p>", ContentType: "HTML"}, "u00001")
+	require.NoError(t, err)
+
+	files, err := pageSyncService.ExportPages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(files))
+	require.Equal(t, "about.de.md", files[0].Path)
+	require.Equal(t, "about.md", files[1].Path)
+	require.Equal(t, aboutFile, files[1].Content)
+	require.Equal(t, "docs/intro.html", files[2].Path)
+
+	// exported files are the same pages
+	changes, err := pageSyncService.ImportPages(ctx, files, false, "u00002")
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	files = []*pb.PageFile{
+		files[0],
+		{Path: "./about.md", Content: "---\r\ntitle: About us\r\nstatus: published\r\nkeywords: [company, Company]\r\n---\r\nHello\r\n"},
+		{Path: "news.txt", Content: "---\ntitle: News\n---\nNothing yet"},
+	}
+
+	changes, err = pageSyncService.ImportPages(ctx, files, true, "u00002")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(changes))
+	require.Equal(t, &pb.PageChange{Path: "about.md", Action: "UPDATE", Diff: "-title: About\n+title: About us\n"}, changes[0])
+	require.Equal(t, "news.txt", changes[1].Path)
+	require.Equal(t, "CREATE", changes[1].Action)
+	require.Contains(t, changes[1].Diff, "+content_type: PLAIN_TEXT\n+status: DRAFT\n")
+	require.Equal(t, "docs/intro.html", changes[2].Path)
+	require.Equal(t, "DELETE", changes[2].Action)
+
+	// dry run changes nothing
+	page, err := pageService.GetPage(ctx, "about")
+	require.NoError(t, err)
+	require.Equal(t, "About", page.Title)
+
+	changes, err = pageSyncService.ImportPages(ctx, files, false, "u00002")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(changes))
+
+	page, err = pageService.GetPage(ctx, "about")
+	require.NoError(t, err)
+	require.Equal(t, "About us", page.Title)
+	require.Equal(t, "u00002", page.UpdatedBy)
+
+	page, err = pageService.GetPage(ctx, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.ContentType_PLAIN_TEXT, page.ContentType)
+	require.Equal(t, "Nothing yet", page.Content)
+
+	_, err = pageService.GetPage(ctx, "docs/intro")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	changes, err = pageSyncService.ImportPages(ctx, files, false, "u00002")
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	// the failed import changes nothing
+	_, err = pageSyncService.ImportPages(ctx, append(files,
+		&pb.PageFile{Path: "a.md", Content: "---\ntitle: A\n---\n"},
+		&pb.PageFile{Path: "b.json", Content: "---\ntitle: B\n---\n{"}), false, "u00002")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "b.json")
+	_, err = pageService.GetPage(ctx, "a")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	for _, invalid := range [][]*pb.PageFile{
+		{{Path: "About Us.md", Content: "---\ntitle: x\n---\n"}},
+		{{Path: "about.doc", Content: "---\ntitle: x\n---\n"}},
+		{{Path: "about.en.md", Content: "---\ntitle: x\n---\n"}},
+		{{Path: "about.md", Content: "---\ntitle: x\nauthor: y\n---\n"}},
+		{{Path: "about.md", Content: "---\ntitle: x\nstatus: hidden\n---\n"}},
+		{{Path: "about.md", Content: "---\ntitle: x\n"}},
+		{{Path: "about.md"}, {Path: "about.html"}},
+		{{Path: "contact.de.md", Content: "---\ntitle: x\n---\n"}},
+	} {
+		_, err = pageSyncService.ImportPages(ctx, invalid, true, "u00002")
+		require.Error(t, err, invalid[0].Path)
+	}
+
+	locales, err := pageService.PageLocales(ctx, "about")
+	require.NoError(t, err)
+	require.Equal(t, []string{"de"}, locales)
+
+}
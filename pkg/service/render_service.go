@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"container/list"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type renderCacheEntry struct {
+	key      string
+	version  int64
+	updated  int64
+	rendered *api.RenderedPage
+}
+
+// LRU cache of rendered pages keyed by name and locale, entry is valid only for the same version of the page
+type implRenderService struct {
+	HtmlSanitizer   api.HtmlSanitizer  `inject`
+	Renderers       []api.PageRenderer  `inject`
+
+	CacheSize       int   `value:"page.render-cache-size,default=1000"`
+
+	renderers  map[pb.ContentType]api.PageRenderer
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      list.List
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+func RenderService() api.RenderService {
+	return &implRenderService{
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (t *implRenderService) PostConstruct() error {
+	t.renderers = make(map[pb.ContentType]api.PageRenderer)
+	for _, renderer := range t.Renderers {
+		if _, ok := t.renderers[renderer.ContentType()]; ok {
+			return errors.Errorf("duplicate renderer of content type '%s'", renderer.ContentType())
+		}
+		t.renderers[renderer.ContentType()] = renderer
+	}
+	return nil
+}
+
+func (t *implRenderService) ParseContentType(ct string) (pb.ContentType, error) {
+	value, ok := pb.ContentType_value[strings.ToUpper(strings.TrimSpace(ct))]
+	if !ok {
+		return 0, errors.Errorf("invalid content type '%s'", ct)
+	}
+	contentType := pb.ContentType(value)
+	if _, ok := t.renderers[contentType]; !ok {
+		return 0, errors.Errorf("content type '%s' has no renderer", ct)
+	}
+	return contentType, nil
+}
+
+func (t *implRenderService) ContentTypes() []string {
+	var names []string
+	for contentType := range t.renderers {
+		names = append(names, contentType.String())
+	}
+	// enum order, markdown goes first
+	sort.Slice(names, func(i, j int) bool {
+		return pb.ContentType_value[names[i]] < pb.ContentType_value[names[j]]
+	})
+	return names
+}
+
+func (t *implRenderService) Render(contentType pb.ContentType, content string) (string, []string, error) {
+	renderer, ok := t.renderers[contentType]
+	if !ok {
+		return "", nil, errors.Errorf("content type '%s' has no renderer", contentType)
+	}
+	html, err := renderer.Render(content)
+	if err != nil {
+		return "", nil, err
+	}
+	html, removed := t.HtmlSanitizer.Sanitize(contentType, html)
+	return html, removed, nil
+}
+
+func (t *implRenderService) RenderPage(page *pb.PageEntity) (*api.RenderedPage, error) {
+	return t.cached(renderCacheKey(page), page.Version, page.UpdTimestamp, func() (*api.RenderedPage, error) {
+		return t.render(page)
+	})
+}
+
+func (t *implRenderService) RenderFragment(fragment *pb.FragmentEntity) (*api.RenderedPage, error) {
+	return t.cached(fragmentCacheKey(fragment.Name), fragment.Version, fragment.UpdTimestamp, func() (*api.RenderedPage, error) {
+		content, removed, err := t.Render(fragment.ContentType, fragment.Content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "render fragment '%s'", fragment.Name)
+		}
+		return &api.RenderedPage{
+			Content:      content,
+			LastModified: fragment.UpdTimestamp,
+			Removed:      removed,
+			Variables:    pageVariables(content),
+		}, nil
+	})
+}
+
+func (t *implRenderService) cached(key string, version, updated int64, render func() (*api.RenderedPage, error)) (*api.RenderedPage, error) {
+
+	t.mu.Lock()
+	if el, ok := t.entries[key]; ok {
+		entry := el.Value.(*renderCacheEntry)
+		if entry.version == version && entry.updated == updated {
+			t.lru.MoveToFront(el)
+			t.mu.Unlock()
+			t.hits.Inc()
+			return entry.rendered, nil
+		}
+	}
+	t.mu.Unlock()
+
+	t.misses.Inc()
+	rendered, err := render()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.CacheSize > 0 {
+		t.mu.Lock()
+		t.put(&renderCacheEntry{
+			key:      key,
+			version:  version,
+			updated:  updated,
+			rendered: rendered,
+		})
+		t.mu.Unlock()
+	}
+
+	return rendered, nil
+}
+
+// page names have no ':'
+func renderCacheKey(page *pb.PageEntity) string {
+	if page.Locale == "" {
+		return page.Name
+	}
+	return page.Name + ":" + page.Locale
+}
+
+// fragments do not collide with pages, invalidated by Invalidate("fragment:<name>")
+func fragmentCacheKey(name string) string {
+	return "fragment:" + name
+}
+
+func (t *implRenderService) render(page *pb.PageEntity) (*api.RenderedPage, error) {
+
+	content, removed, err := t.Render(page.ContentType, page.Content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "render page '%s'", page.Name)
+	}
+
+	lastModified := page.UpdTimestamp
+	if lastModified == 0 {
+		lastModified = page.CreTimestamp
+	}
+
+	return &api.RenderedPage{
+		Content:      content,
+		LastModified: lastModified,
+		Removed:      removed,
+		Variables:    pageVariables(content),
+	}, nil
+}
+
+// {{.Name}} or {{ .Name }}, only plain names, no pipelines or functions
+var pageVariableRe = regexp.MustCompile(`\{\{\s*\.([A-Za-z][A-Za-z0-9]*)\s*\}\}`)
+
+// sorted names of the variables in the content
+func pageVariables(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range pageVariableRe.FindAllStringSubmatch(content, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *implRenderService) Expand(rendered *api.RenderedPage, values map[string]string) *api.RenderedPage {
+
+	if !strings.Contains(rendered.Content, "{{") {
+		return rendered
+	}
+
+	// content is sanitized already, so values are escaped to stay text
+	content := pageVariableRe.ReplaceAllStringFunc(rendered.Content, func(match string) string {
+		name := pageVariableRe.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return html.EscapeString(value)
+		}
+		return match
+	})
+
+	return &api.RenderedPage{
+		Content:      content,
+		LastModified: rendered.LastModified,
+		Removed:      rendered.Removed,
+		Variables:    rendered.Variables,
+	}
+}
+
+func (t *implRenderService) put(entry *renderCacheEntry) {
+
+	if el, ok := t.entries[entry.key]; ok {
+		el.Value = entry
+		t.lru.MoveToFront(el)
+		return
+	}
+
+	t.entries[entry.key] = t.lru.PushFront(entry)
+
+	for t.lru.Len() > t.CacheSize {
+		el := t.lru.Back()
+		t.lru.Remove(el)
+		delete(t.entries, el.Value.(*renderCacheEntry).key)
+	}
+}
+
+func (t *implRenderService) Invalidate(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// translations go with the page
+	for key, el := range t.entries {
+		if key == name || strings.HasPrefix(key, name + ":") {
+			t.lru.Remove(el)
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *implRenderService) CacheStats() (hits, misses int64) {
+	return t.hits.Load(), t.misses.Load()
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRenderService(t *testing.T) {
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"page.render-cache-size": 2,
+	}}
+
+	renderService := service.RenderService()
+
+	ctx, err := glue.New(properties, service.HtmlSanitizer(), renderService,
+		service.MarkdownRenderer(),
+		service.HtmlRenderer(),
+		service.PlainTextRenderer(),
+		service.JsonBlocksRenderer())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	page := &pb.PageEntity{
+		Name:         "about",
+		Title:        "About",
+		Content:      "Hello *world*<script>alert(1)</script>",
+		Version:      1,
+		UpdTimestamp: 1000,
+	}
+
+	require.Equal(t, []string{"MARKDOWN", "HTML", "PLAIN_TEXT", "JSON_BLOCKS"}, renderService.ContentTypes())
+
+	first := renderPage(t, renderService, page)
+	require.Equal(t, "<p>Hello <em>world</em></p>\n", first.Content)
+	require.Equal(t, []string{"<script>"}, first.Removed)
+	require.Equal(t, int64(1000), first.LastModified)
+
+	second := renderPage(t, renderService, page)
+	require.Same(t, first, second)
+
+	hits, misses := renderService.CacheStats()
+	require.Equal(t, int64(1), hits)
+	require.Equal(t, int64(1), misses)
+
+	// new version is rendered again
+	page.Content = "Hello"
+	page.Version = 2
+	third := renderPage(t, renderService, page)
+	require.Equal(t, "<p>Hello</p>\n", third.Content)
+
+	// least recently used page is evicted
+	renderPage(t, renderService, &pb.PageEntity{Name: "a", Content: "a"})
+	renderPage(t, renderService, &pb.PageEntity{Name: "b", Content: "b"})
+	renderPage(t, renderService, page)
+
+	hits, misses = renderService.CacheStats()
+	require.Equal(t, int64(1), hits)
+	require.Equal(t, int64(5), misses)
+
+	renderPage(t, renderService, &pb.PageEntity{Name: "b", Content: "b"})
+	renderService.Invalidate("b")
+	renderPage(t, renderService, &pb.PageEntity{Name: "b", Content: "b"})
+
+	hits, misses = renderService.CacheStats()
+	require.Equal(t, int64(2), hits)
+	require.Equal(t, int64(6), misses)
+
+}
+
+func renderPage(t *testing.T, renderService api.RenderService, page *pb.PageEntity) *api.RenderedPage {
+	rendered, err := renderService.RenderPage(page)
+	require.NoError(t, err)
+	return rendered
+}
+
+func TestPageRenderers(t *testing.T) {
+
+	renderService := service.RenderService()
+
+	ctx, err := glue.New(service.HtmlSanitizer(), renderService,
+		service.PlainTextRenderer(),
+		service.JsonBlocksRenderer())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	_, err = renderService.ParseContentType("markdown")
+	require.Error(t, err)
+
+	contentType, err := renderService.ParseContentType("plain_text")
+	require.NoError(t, err)
+
+	html, _, err := renderService.Render(contentType, "Hello <world>\nline two\n\nSecond")
+	require.NoError(t, err)
+	require.Equal(t, "<p>Hello &lt;world&gt;<br>line two</p>\n<p>Second</p>\n", html)
+
+	blocks := `{"blocks": [
+		{"type": "heading", "text": "Title"},
+		{"type": "paragraph", "text": "Text & more"},
+		{"type": "image", "src": "javascript:alert(1)", "alt": "x"},
+		{"type": "callout", "style": "warning", "text": "Careful"}
+	]}`
+	html, removed, err := renderService.Render(pb.ContentType_JSON_BLOCKS, blocks)
+	require.NoError(t, err)
+	require.Equal(t, "<h2>Title</h2>\n<p>Text &amp; more</p>\n<figure><img alt=\"x\"/></figure>\n<div class=\"notification is-warning\">Careful</div>\n", html)
+	require.Equal(t, []string{"img.src"}, removed)
+
+	_, _, err = renderService.Render(pb.ContentType_JSON_BLOCKS, `{"blocks": [{"type": "video"}]}`)
+	require.Error(t, err)
+
+	_, _, err = renderService.Render(pb.ContentType_JSON_BLOCKS, `[`)
+	require.Error(t, err)
+
+}
+
+func TestMarkdownRenderer(t *testing.T) {
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"markdown.toc-depth": 2,
+	}}
+
+	renderService := service.RenderService()
+
+	ctx, err := glue.New(properties, service.HtmlSanitizer(), renderService, service.MarkdownRenderer())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	content := "[TOC]\n\n# Intro\n\nText[^1]\n\n## Setup\n\n### Details\n\n## Setup\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n[^1]: Note\n"
+	html, removed, err := renderService.Render(pb.ContentType_MARKDOWN, content)
+	require.NoError(t, err)
+	require.Empty(t, removed)
+	require.Contains(t, html, `<nav class="toc"><ul><li><a href="#intro">Intro</a><ul><li><a href="#setup">Setup</a></li><li><a href="#setup-1">Setup</a></li></ul></li></ul></nav>`)
+	require.Contains(t, html, `<h2 id="setup-1">Setup</h2>`)
+	require.Contains(t, html, `<h3 id="details">Details</h3>`)
+	require.Contains(t, html, `<th>a</th>`)
+	require.Contains(t, html, `<li id="fn:1">Note`)
+
+	page := &pb.PageEntity{
+		Name:    "welcome",
+		Content: "Hi {{ .FirstName }}, welcome to [{{.Project}}]({{.Url}}/about) {{.Unknown}} {{template \"x\"}}",
+		Version: 1,
+	}
+	rendered := renderPage(t, renderService, page)
+	require.Equal(t, []string{"FirstName", "Project", "Unknown", "Url"}, rendered.Variables)
+
+	expanded := renderService.Expand(rendered, map[string]string{
+		"FirstName": "<b>Bob</b>",
+		"Project":   "Light",
+		"Url":       "https://example.com",
+	})
+	require.Equal(t, "<p>Hi &lt;b&gt;Bob&lt;/b&gt;, welcome to <a href=\"https://example.com/about\">Light</a> {{.Unknown}} {{template “x”}}</p>\n", expanded.Content)
+
+	other := renderService.Expand(rendered, map[string]string{"FirstName": "Alice"})
+	require.Contains(t, other.Content, `Hi Alice, welcome to <a href="{{.Url}}/about">{{.Project}}</a>`)
+
+	_, err = glue.New(&glue.PropertySource{Map: map[string]interface{}{
+		"markdown.extensions": "tables;includes",
+	}}, service.MarkdownRenderer())
+	require.Error(t, err)
+}
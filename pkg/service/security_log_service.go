@@ -16,12 +16,15 @@ package service
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/codeallergy/store"
 	"github.com/codeallergy/template/pkg/api"
 	"github.com/codeallergy/template/pkg/pb"
 	"github.com/codeallergy/template/pkg/utils"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"time"
 )
@@ -31,6 +34,7 @@ type implSecurityLogService struct {
 	Log            *zap.Logger          `inject`
 	HostStorage    store.DataStore      `inject:"bean=host-storage"`
 	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+	Sinks          []api.SecurityLogSink  `inject:"optional"`
 
 	LogTtl   int   `value:"security-log.ttl,default=31536000"`  // one year ttl
 }
@@ -39,11 +43,18 @@ const (
 	DDMMYYYYhhmmss = "2006-01-02 15:04:05.000"
 )
 
+// event names written before event types were introduced
+var legacyEventTypes = map[string]pb.SecurityEventType{
+	"Login":         pb.SecurityEventType_LOGIN,
+	"Registration":  pb.SecurityEventType_REGISTRATION,
+	"ResetPassword": pb.SecurityEventType_RESET_PASSWORD,
+}
+
 func SecurityLogService() api.SecurityLogService {
 	return &implSecurityLogService{}
 }
 
-func (t *implSecurityLogService) LogEvent(ctx context.Context, userId, eventName, remoteIP, userAgent string) (err error) {
+func (t *implSecurityLogService) LogEvent(ctx context.Context, userId string, event *pb.SecurityLogEntity) (err error) {
 
 	userId = utils.NormalizeUserId(userId)
 	if userId == "" {
@@ -53,9 +64,21 @@ func (t *implSecurityLogService) LogEvent(ctx context.Context, userId, eventName
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
+		if err == nil {
+			t.emit(userId, event)
+		}
 	}()
 
+	last, lastTime, err := t.lastEvent(ctx, userId)
+	if err != nil {
+		return err
+	}
+
 	current := time.Now()
+	if !current.After(lastTime) {
+		// keep the chain in the key order even if clock goes back
+		current = lastTime.Add(time.Millisecond)
+	}
 tryAgain:
 	utc := current.UTC()
 
@@ -67,14 +90,66 @@ tryAgain:
 		goto tryAgain
 	}
 
-	event := &pb.SecurityLogEntity{
-		EventName: eventName,
-		EventTime: current.Unix(),
-		RemoteIp:  remoteIP,
-		UserAgent: userAgent,
+	if event.EventName == "" {
+		event.EventName = event.EventType.String()
+	}
+	event.EventTime = current.Unix()
+	event.PrevHash = last.Hash
+	event.Hash = nil
+
+	event.Hash, err = chainHash(event)
+	if err != nil {
+		return err
 	}
 
 	err = t.HostStorage.Set(ctx).ByKey("%s:user:security-log:%s", userId, utc.Format(DDMMYYYYhhmmss)).WithTtl(t.LogTtl).Proto(event)
+	if err != nil {
+		return err
+	}
+
+	// head expires with the last entry, otherwise it points to the removed entry
+	err = t.HostStorage.Set(ctx).ByKey("%s:user:security-log-head", userId).WithTtl(t.LogTtl).String(hex.EncodeToString(event.Hash))
+	return
+}
+
+func (t *implSecurityLogService) emit(userId string, event *pb.SecurityLogEntity) {
+	for _, sink := range t.Sinks {
+		if err := sink.Emit(userId, event); err != nil {
+			t.Log.Error("SecurityLogSink", zap.String("sink", sink.BeanName()), zap.String("userId", userId), zap.Error(err))
+		}
+	}
+}
+
+// common json representation of the security event for all sinks
+func securityEventJSON(userId string, event *pb.SecurityLogEntity) ([]byte, error) {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		UserId string          `json:"user_id"`
+		Event  json.RawMessage `json:"event"`
+	}{
+		UserId: userId,
+		Event:  data,
+	})
+}
+
+func (t *implSecurityLogService) lastEvent(ctx context.Context, userId string) (last *pb.SecurityLogEntity, lastTime time.Time, err error) {
+	last = new(pb.SecurityLogEntity)
+	err = t.HostStorage.Enumerate(ctx).ByPrefix("%s:user:security-log:", userId).
+		Seek("%s:user:security-log:\xff", userId).
+		Reverse().
+		WithBatchSize(1).
+		DoProto(func() proto.Message {
+			return new(pb.SecurityLogEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.SecurityLogEntity); ok {
+				last = v
+				lastTime, err = parseLogKeyTime(entry.Key)
+			}
+			return false
+		})
 	return
 }
 
@@ -100,6 +175,9 @@ func (t *implSecurityLogService) EnumEvents(ctx context.Context, userId string,
 			return new(pb.SecurityLogEntity)
 		}, func(entry *store.ProtoEntry) bool {
 			if v, ok := entry.Value.(*pb.SecurityLogEntity); ok {
+				if v.EventType == pb.SecurityEventType_UNKNOWN_EVENT {
+					v.EventType = legacyEventTypes[v.EventName]
+				}
 				return cb(v)
 			}
 			return true
@@ -107,3 +185,27 @@ func (t *implSecurityLogService) EnumEvents(ctx context.Context, userId string,
 
 }
 
+func (t *implSecurityLogService) VerifyEvents(ctx context.Context, userId string) (int, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return 0, errors.New("userId is empty")
+	}
+
+	head, err := t.HostStorage.Get(ctx).ByKey("%s:user:security-log-head", userId).ToString()
+	if err != nil {
+		return 0, err
+	}
+
+	var verifier chainVerifier
+	err = t.EnumEvents(ctx, userId, func(item *pb.SecurityLogEntity) bool {
+		withoutHash := proto.Clone(item).(*pb.SecurityLogEntity)
+		withoutHash.Hash = nil
+		return verifier.next(item.EventTime, item.Hash, item.PrevHash, withoutHash)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return verifier.finish(head)
+}
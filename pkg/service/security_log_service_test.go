@@ -15,8 +15,19 @@
 package service_test
 
 import (
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/codeallergy/template/pkg/service"
+	"go.uber.org/zap"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -41,6 +52,152 @@ func TestDateFormatter(t *testing.T) {
 
 }
 
+func TestSecurityLogEvents(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	securityLogService := service.SecurityLogService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, securityLogService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifySecurityLogEvents(t, securityLogService)
+	verifySecurityLogChain(t, securityLogService, hostStore)
+
+}
+
+func verifySecurityLogEvents(t *testing.T, securityLogService api.SecurityLogService) {
+
+	ctx := context.Background()
+	userId := "u00001"
+
+	err := securityLogService.LogEvent(ctx, userId, &pb.SecurityLogEntity{
+		EventType: pb.SecurityEventType_LOGIN,
+		Outcome:   pb.SecurityEventOutcome_FAILURE,
+		RemoteIp:  "127.0.0.1",
+		Details:   map[string]string{"reason": "invalid password"},
+	})
+	require.NoError(t, err)
+
+	err = securityLogService.LogEvent(ctx, userId, &pb.SecurityLogEntity{
+		EventType: pb.SecurityEventType_ROLE_CHANGE,
+		ActorId:   "u00002",
+		Details:   map[string]string{"role": "ADMIN"},
+	})
+	require.NoError(t, err)
+
+	var list []*pb.SecurityLogEntity
+	err = securityLogService.EnumEvents(ctx, userId, func(item *pb.SecurityLogEntity) bool {
+		list = append(list, item)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+
+	require.Equal(t, pb.SecurityEventType_LOGIN, list[0].EventType)
+	require.Equal(t, pb.SecurityEventOutcome_FAILURE, list[0].Outcome)
+	require.Equal(t, "LOGIN", list[0].EventName)
+	require.Equal(t, "invalid password", list[0].Details["reason"])
+	require.NotEqual(t, int64(0), list[0].EventTime)
+
+	require.Equal(t, pb.SecurityEventType_ROLE_CHANGE, list[1].EventType)
+	require.Equal(t, pb.SecurityEventOutcome_SUCCESS, list[1].Outcome)
+	require.Equal(t, "u00002", list[1].ActorId)
+
+	err = securityLogService.LogEvent(ctx, "", &pb.SecurityLogEntity{EventType: pb.SecurityEventType_LOGIN})
+	require.Error(t, err)
+
+}
+
+func verifySecurityLogChain(t *testing.T, securityLogService api.SecurityLogService, hostStore store.DataStore) {
+
+	ctx := context.Background()
+
+	logEvents := func(userId string) [][]byte {
+		for _, eventType := range []pb.SecurityEventType{pb.SecurityEventType_REGISTRATION, pb.SecurityEventType_LOGIN, pb.SecurityEventType_LOGOUT} {
+			err := securityLogService.LogEvent(ctx, userId, &pb.SecurityLogEntity{EventType: eventType})
+			require.NoError(t, err)
+		}
+		var keys [][]byte
+		err := hostStore.Enumerate(ctx).ByPrefix("%s:user:security-log:", userId).Do(func(entry *store.RawEntry) bool {
+			keys = append(keys, append([]byte(nil), entry.Key...))
+			return true
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(keys))
+		return keys
+	}
+
+	// untouched
+	logEvents("u00010")
+	cnt, err := securityLogService.VerifyEvents(ctx, "u00010")
+	require.NoError(t, err)
+	require.Equal(t, 3, cnt)
+
+	// modified content
+	keys := logEvents("u00011")
+	event := new(pb.SecurityLogEntity)
+	require.NoError(t, hostStore.Get(ctx).ByRawKey(keys[1]).ToProto(event))
+	event.RemoteIp = "10.0.0.1"
+	require.NoError(t, hostStore.Set(ctx).ByRawKey(keys[1]).Proto(event))
+	_, err = securityLogService.VerifyEvents(ctx, "u00011")
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+	require.True(t, strings.Contains(err.Error(), "entry 2"))
+
+	// reordered entries
+	keys = logEvents("u00012")
+	first, err := hostStore.Get(ctx).ByRawKey(keys[0]).ToBinary()
+	require.NoError(t, err)
+	second, err := hostStore.Get(ctx).ByRawKey(keys[1]).ToBinary()
+	require.NoError(t, err)
+	require.NoError(t, hostStore.Set(ctx).ByRawKey(keys[0]).Binary(second))
+	require.NoError(t, hostStore.Set(ctx).ByRawKey(keys[1]).Binary(first))
+	_, err = securityLogService.VerifyEvents(ctx, "u00012")
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+
+	// deleted entry in the middle
+	keys = logEvents("u00013")
+	require.NoError(t, hostStore.Remove(ctx).ByRawKey(keys[1]).Do())
+	_, err = securityLogService.VerifyEvents(ctx, "u00013")
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+	require.True(t, strings.Contains(err.Error(), "does not link"))
+
+	// deleted last entry
+	keys = logEvents("u00014")
+	require.NoError(t, hostStore.Remove(ctx).ByRawKey(keys[2]).Do())
+	_, err = securityLogService.VerifyEvents(ctx, "u00014")
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+	require.True(t, strings.Contains(err.Error(), "head"))
+
+	// whole log was removed, the head is left
+	keys = logEvents("u00015")
+	for _, key := range keys {
+		require.NoError(t, hostStore.Remove(ctx).ByRawKey(key).Do())
+	}
+	_, err = securityLogService.VerifyEvents(ctx, "u00015")
+	require.True(t, errors.Is(err, service.ErrBrokenLogChain))
+	require.True(t, strings.Contains(err.Error(), "head"))
+
+}
+
 type eventList struct {
 	eventMap sync.Map
 }
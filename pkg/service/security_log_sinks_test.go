@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/pb"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type webhookDelivery struct {
+	body      []byte
+	signature string
+}
+
+func TestSecurityLogSinks(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+
+	// first delivery fails to check the retry from the persistent queue
+	var attempts int32
+	deliveries := make(chan webhookDelivery, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- webhookDelivery{body: body, signature: r.Header.Get("X-Signature-256")}
+	}))
+	defer webhook.Close()
+
+	filePath := filepath.Join(hostDir, "security.log")
+
+	properties := &glue.PropertySource{Map: map[string]interface{}{
+		"security-log.file-sink.path":           filePath,
+		"security-log.syslog-sink.address":      "udp://" + udp.LocalAddr().String(),
+		"security-log.webhook-sink.url":         webhook.URL,
+		"security-log.webhook-sink.secret":      "webhook-secret",
+		"security-log.webhook-sink.retry-seconds": 1,
+	}}
+
+	securityLogService := service.SecurityLogService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, properties,
+		securityLogService,
+		service.FileLogSink(),
+		service.SyslogLogSink(),
+		service.WebhookLogSink())
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	err = securityLogService.LogEvent(context.Background(), "u00001", &pb.SecurityLogEntity{
+		EventType: pb.SecurityEventType_LOGIN,
+		Outcome:   pb.SecurityEventOutcome_FAILURE,
+		RemoteIp:  "127.0.0.1",
+		Details:   map[string]string{"reason": "invalid password"},
+	})
+	require.NoError(t, err)
+
+	// file sink
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Equal(t, 1, len(lines))
+	verifySinkJSON(t, []byte(lines[0]))
+
+	// syslog sink, authpriv.warning
+	buf := make([]byte, 8192)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	require.True(t, strings.HasPrefix(msg, "<84>1 "), msg)
+	require.True(t, strings.Contains(msg, " LOGIN [event@32473 user=\"u00001\" outcome=\"FAILURE\" ip=\"127.0.0.1\"] {"), msg)
+
+	// webhook sink
+	select {
+	case d := <-deliveries:
+		mac := hmac.New(sha256.New, []byte("webhook-secret"))
+		mac.Write(d.body)
+		require.Equal(t, "sha256=" + hex.EncodeToString(mac.Sum(nil)), d.signature)
+		verifySinkJSON(t, d.body)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "webhook was not delivered")
+	}
+	require.True(t, atomic.LoadInt32(&attempts) >= 2)
+
+}
+
+func verifySinkJSON(t *testing.T, data []byte) {
+	var out struct {
+		UserId string `json:"user_id"`
+		Event  struct {
+			EventType string            `json:"event_type"`
+			Outcome   string            `json:"outcome"`
+			Details   map[string]string `json:"details"`
+		} `json:"event"`
+	}
+	require.NoError(t, json.Unmarshal(data, &out))
+	require.Equal(t, "u00001", out.UserId)
+	require.Equal(t, "LOGIN", out.Event.EventType)
+	require.Equal(t, "FAILURE", out.Event.Outcome)
+	require.Equal(t, "invalid password", out.Event.Details["reason"])
+}
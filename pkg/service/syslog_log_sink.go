@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityInfo     = 6
+
+	// reserved for documentation by IANA, there is no registered enterprise number
+	syslogEnterpriseId = 32473
+)
+
+// sends security events in RFC 5424 format, TCP uses octet counting framing from RFC 6587,
+// messages are queued and written in background, so a slow syslog server does not block requests
+type implSyslogLogSink struct {
+	Log            *zap.Logger          `inject`
+
+	Address        string   `value:"security-log.syslog-sink.address,default="`  // udp://host:514 or tcp://host:601
+	AppName        string   `value:"security-log.syslog-sink.app-name,default=template"`
+	TimeoutSeconds int      `value:"security-log.syslog-sink.timeout-seconds,default=5"`
+	QueueSize      int      `value:"security-log.syslog-sink.queue-size,default=1000"`
+
+	network   string
+	host      string
+	hostname  string
+
+	queue      chan []byte
+	conn       net.Conn
+	done       chan struct{}
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+func SyslogLogSink() api.SecurityLogSink {
+	return &implSyslogLogSink{}
+}
+
+func (t *implSyslogLogSink) BeanName() string {
+	return "syslog_log_sink"
+}
+
+func (t *implSyslogLogSink) PostConstruct() (err error) {
+	if t.Address == "" {
+		return nil
+	}
+
+	i := strings.Index(t.Address, "://")
+	if i == -1 {
+		return errors.Errorf("invalid syslog address '%s', expected udp://host:port or tcp://host:port", t.Address)
+	}
+	t.network, t.host = t.Address[:i], t.Address[i+3:]
+	if t.network != "udp" && t.network != "tcp" {
+		return errors.Errorf("unsupported syslog network '%s'", t.network)
+	}
+
+	t.hostname, err = os.Hostname()
+	if err != nil || t.hostname == "" {
+		t.hostname = "-"
+	}
+
+	if t.QueueSize <= 0 {
+		t.QueueSize = 1
+	}
+	t.queue = make(chan []byte, t.QueueSize)
+	t.done = make(chan struct{})
+
+	t.wg.Add(1)
+	go t.run()
+	return nil
+}
+
+func (t *implSyslogLogSink) Emit(userId string, event *pb.SecurityLogEntity) error {
+	if t.queue == nil {
+		return nil
+	}
+
+	data, err := securityEventJSON(userId, event)
+	if err != nil {
+		return err
+	}
+
+	severity := syslogSeverityInfo
+	if event.Outcome == pb.SecurityEventOutcome_FAILURE {
+		severity = syslogSeverityWarning
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s [event@%d user=\"%s\" outcome=\"%s\" ip=\"%s\"] %s",
+		syslogFacilityAuthPriv * 8 + severity,
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		t.hostname,
+		t.AppName,
+		os.Getpid(),
+		event.EventType.String(),
+		syslogEnterpriseId,
+		syslogParamValue(userId),
+		event.Outcome.String(),
+		syslogParamValue(event.RemoteIp),
+		data)
+
+	if t.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	select {
+	case t.queue <- []byte(msg):
+		return nil
+	default:
+		return errors.Errorf("syslog queue of %d messages is full", t.QueueSize)
+	}
+}
+
+func (t *implSyslogLogSink) run() {
+	defer t.wg.Done()
+
+	for {
+		select {
+		case msg := <-t.queue:
+			t.write(msg)
+		case <-t.done:
+			// write what is already queued
+			for {
+				select {
+				case msg := <-t.queue:
+					t.write(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// the message is dropped on failure, the connection is made again for the next one
+func (t *implSyslogLogSink) write(msg []byte) {
+
+	var err error
+	if t.conn == nil {
+		t.conn, err = net.DialTimeout(t.network, t.host, time.Duration(t.TimeoutSeconds) * time.Second)
+		if err != nil {
+			t.conn = nil
+			t.Log.Warn("SyslogLogSink", zap.String("address", t.Address), zap.Error(err))
+			return
+		}
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(time.Duration(t.TimeoutSeconds) * time.Second))
+	if _, err = t.conn.Write(msg); err != nil {
+		t.conn.Close()
+		t.conn = nil
+		t.Log.Warn("SyslogLogSink", zap.String("address", t.Address), zap.Error(err))
+	}
+}
+
+func syslogParamValue(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	return r.Replace(s)
+}
+
+func (t *implSyslogLogSink) Destroy() (err error) {
+	t.closeOnce.Do(func() {
+		if t.done != nil {
+			close(t.done)
+			t.wg.Wait()
+		}
+		if t.conn != nil {
+			err = t.conn.Close()
+			t.conn = nil
+		}
+	})
+	return
+}
@@ -0,0 +1,443 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	trafficHourFormat = "2006010215"
+	trafficDayFormat  = "20060102"
+
+	// salts and seen visitors outlive the day by the clock skew of the late flush
+	trafficSeenTtl = 172800
+
+	// the rest of referrers of the bucket are counted as 'other'
+	maxTrafficReferrers = 1000
+
+	// range limit of TrafficStats
+	maxTrafficPoints = 1000
+)
+
+var trafficBotRe = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|preview|monitor|curl|wget|python|headless`)
+
+type trafficView struct {
+	hour      time.Time
+	page      string
+	referrer  string
+	visitor   string
+}
+
+type implTrafficService struct {
+	Log            *zap.Logger          `inject`
+	HostStorage    store.DataStore      `inject:"bean=host-storage"`
+	TransactionalManager  store.TransactionalManager  `inject:"bean=host-storage"`
+
+	WebappURL        string  `value:"webapp.url,default=https://localhost:8443"`
+	IntervalSeconds  int     `value:"traffic.flush-interval-seconds,default=60"`
+	MaxPending       int     `value:"traffic.max-pending,default=10000"`
+	HourlyTtl        int     `value:"traffic.hourly-ttl,default=1209600"`  // two weeks ttl
+	DailyTtl         int     `value:"traffic.daily-ttl,default=63072000"`  // two years ttl
+
+	siteHost  string
+
+	mu        sync.Mutex
+	pending   []*trafficView
+	dropped   int
+	saltDay   string
+	salt      []byte
+
+	flushMu    sync.Mutex
+	done       chan struct{}
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+func TrafficService() api.TrafficService {
+	return &implTrafficService{}
+}
+
+func (t *implTrafficService) PostConstruct() error {
+
+	if u, err := url.Parse(t.WebappURL); err == nil {
+		t.siteHost = trafficHost(u.Host)
+	}
+
+	if t.IntervalSeconds <= 0 {
+		return nil
+	}
+
+	t.done = make(chan struct{})
+	t.wg.Add(1)
+	go t.run()
+	return nil
+}
+
+func (t *implTrafficService) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(t.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := t.Flush(context.Background()); err != nil {
+			t.Log.Error("TrafficService", zap.Error(err))
+		}
+	}
+}
+
+func (t *implTrafficService) Destroy() error {
+	t.closeOnce.Do(func() {
+		if t.done != nil {
+			close(t.done)
+			t.wg.Wait()
+		}
+		if err := t.Flush(context.Background()); err != nil {
+			t.Log.Error("TrafficService", zap.Error(err))
+		}
+	})
+	return nil
+}
+
+func (t *implTrafficService) RecordView(ctx context.Context, page, referrer, remoteIP, userAgent string, now time.Time) {
+
+	if page == "" || userAgent == "" || trafficBotRe.MatchString(userAgent) {
+		return
+	}
+
+	utc := now.UTC()
+	visitor, err := t.visitorHash(ctx, utc.Format(trafficDayFormat), remoteIP, userAgent)
+	if err != nil {
+		t.Log.Error("RecordView", zap.String("page", page), zap.Error(err))
+		return
+	}
+
+	view := &trafficView{
+		hour:     utc.Truncate(time.Hour),
+		page:     page,
+		referrer: t.referrerHost(referrer),
+		visitor:  visitor,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.MaxPending > 0 && len(t.pending) >= t.MaxPending {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, view)
+}
+
+// the salt is random and kept only two days, so hashes can not be matched with IPs after that
+func (t *implTrafficService) visitorHash(ctx context.Context, day, remoteIP, userAgent string) (string, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.saltDay != day {
+		salt, err := t.HostStorage.Get(ctx).ByKey("traffic-salt:%s", day).ToBinary()
+		if err != nil {
+			return "", err
+		}
+		if len(salt) == 0 {
+			salt = make([]byte, 32)
+			if _, err := rand.Read(salt); err != nil {
+				return "", err
+			}
+			err = t.HostStorage.Set(ctx).ByKey("traffic-salt:%s", day).WithTtl(trafficSeenTtl).Binary(salt)
+			if err != nil {
+				return "", err
+			}
+		}
+		t.saltDay, t.salt = day, salt
+	}
+
+	// the first address is the client behind proxies
+	if i := strings.IndexByte(remoteIP, ','); i != -1 {
+		remoteIP = remoteIP[:i]
+	}
+
+	h := sha256.New()
+	h.Write(t.salt)
+	h.Write([]byte(strings.TrimSpace(remoteIP)))
+	h.Write([]byte{0})
+	h.Write([]byte(userAgent))
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// host of the external referrer without www, empty for direct views and own pages
+func (t *implTrafficService) referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	host := trafficHost(u.Host)
+	if host == t.siteHost {
+		return ""
+	}
+	return host
+}
+
+func trafficHost(host string) string {
+	host = strings.ToLower(host)
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+func (t *implTrafficService) Flush(ctx context.Context) error {
+
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	views, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.Log.Warn("TrafficService", zap.Int("dropped", dropped))
+	}
+
+	hours := make(map[time.Time][]*trafficView)
+	var order []time.Time
+	for _, view := range views {
+		if _, ok := hours[view.hour]; !ok {
+			order = append(order, view.hour)
+		}
+		hours[view.hour] = append(hours[view.hour], view)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].Before(order[j]) })
+
+	for _, hour := range order {
+		if err := t.flushHour(ctx, hour, hours[hour]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *implTrafficService) flushHour(ctx context.Context, hour time.Time, views []*trafficView) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	day := hour.Format(trafficDayFormat)
+
+	hourly, err := t.getBucket(ctx, "traffic:hour:%s", hour.Format(trafficHourFormat), hour)
+	if err != nil {
+		return err
+	}
+	daily, err := t.getBucket(ctx, "traffic:day:%s", day, hour.Truncate(24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	firstView := func(key string) (bool, error) {
+		if seen[key] {
+			return false, nil
+		}
+		seen[key] = true
+		value, err := t.HostStorage.Get(ctx).ByKey("traffic-seen:%s:%s", day, key).ToBinary()
+		if err != nil || len(value) > 0 {
+			return false, err
+		}
+		return true, t.HostStorage.Set(ctx).ByKey("traffic-seen:%s:%s", day, key).WithTtl(trafficSeenTtl).Binary([]byte{1})
+	}
+
+	for _, view := range views {
+
+		newVisitor, err := firstView(view.visitor)
+		if err != nil {
+			return err
+		}
+		newPageVisitor, err := firstView(view.visitor + ":" + view.page)
+		if err != nil {
+			return err
+		}
+
+		for _, bucket := range []*pb.TrafficBucketEntity{hourly, daily} {
+			bucket.Views++
+			counter, ok := bucket.Pages[view.page]
+			if !ok {
+				counter = new(pb.TrafficCounterEntity)
+				bucket.Pages[view.page] = counter
+			}
+			counter.Views++
+			if newVisitor {
+				bucket.Visitors++
+			}
+			if newPageVisitor {
+				counter.Visitors++
+			}
+			if view.referrer != "" {
+				referrer := view.referrer
+				if _, ok := bucket.Referrers[referrer]; !ok && len(bucket.Referrers) >= maxTrafficReferrers {
+					referrer = "other"
+				}
+				bucket.Referrers[referrer]++
+			}
+		}
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("traffic:hour:%s", hour.Format(trafficHourFormat)).WithTtl(t.HourlyTtl).Proto(hourly)
+	if err != nil {
+		return err
+	}
+	return t.HostStorage.Set(ctx).ByKey("traffic:day:%s", day).WithTtl(t.DailyTtl).Proto(daily)
+}
+
+func (t *implTrafficService) getBucket(ctx context.Context, format, key string, start time.Time) (*pb.TrafficBucketEntity, error) {
+	bucket := new(pb.TrafficBucketEntity)
+	if err := t.HostStorage.Get(ctx).ByKey(format, key).ToProto(bucket); err != nil {
+		return nil, err
+	}
+	bucket.Start = start.Unix()
+	if bucket.Pages == nil {
+		bucket.Pages = make(map[string]*pb.TrafficCounterEntity)
+	}
+	if bucket.Referrers == nil {
+		bucket.Referrers = make(map[string]int64)
+	}
+	return bucket, nil
+}
+
+func (t *implTrafficService) TrafficStats(ctx context.Context, fromTime, toTime int64, hourly bool, top int) (*pb.TrafficStats, error) {
+
+	if toTime < fromTime {
+		return nil, errors.New("nowrap: the end of the range is before the start")
+	}
+
+	step, prefix, format := 24*time.Hour, "traffic:day:", trafficDayFormat
+	if hourly {
+		step, prefix, format = time.Hour, "traffic:hour:", trafficHourFormat
+	}
+
+	from := time.Unix(fromTime, 0).UTC().Truncate(step)
+	to := time.Unix(toTime, 0).UTC()
+	if n := to.Sub(from) / step; n >= maxTrafficPoints {
+		return nil, errors.Errorf("nowrap: the range has more than %d points", maxTrafficPoints)
+	}
+
+	stats := &pb.TrafficStats{
+		FromTime: from.Unix(),
+		ToTime:   toTime,
+	}
+	index := make(map[int64]*pb.TrafficPoint)
+	for start := from; !start.After(to); start = start.Add(step) {
+		point := &pb.TrafficPoint{Start: start.Unix()}
+		stats.Points = append(stats.Points, point)
+		index[point.Start] = point
+	}
+
+	pages := make(map[string]*pb.TrafficItem)
+	referrers := make(map[string]*pb.TrafficItem)
+
+	err := t.HostStorage.Enumerate(ctx).ByPrefix(prefix).
+		Seek(prefix + from.Format(format)).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.TrafficBucketEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			bucket, ok := entry.Value.(*pb.TrafficBucketEntity)
+			if !ok {
+				return true
+			}
+			if bucket.Start > to.Unix() {
+				return false
+			}
+			point, ok := index[bucket.Start]
+			if !ok {
+				return true
+			}
+			point.Views += bucket.Views
+			point.Visitors += bucket.Visitors
+			stats.Views += bucket.Views
+			stats.Visitors += bucket.Visitors
+			for name, counter := range bucket.Pages {
+				item := trafficItem(pages, name)
+				item.Views += counter.Views
+				item.Visitors += counter.Visitors
+			}
+			for name, views := range bucket.Referrers {
+				trafficItem(referrers, name).Views += views
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.TopPages = topTrafficItems(pages, top)
+	stats.TopReferrers = topTrafficItems(referrers, top)
+	return stats, nil
+}
+
+func trafficItem(items map[string]*pb.TrafficItem, name string) *pb.TrafficItem {
+	item, ok := items[name]
+	if !ok {
+		item = &pb.TrafficItem{Name: name}
+		items[name] = item
+	}
+	return item
+}
+
+// the most viewed first
+func topTrafficItems(items map[string]*pb.TrafficItem, top int) []*pb.TrafficItem {
+	list := make([]*pb.TrafficItem, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Views != list[j].Views {
+			return list[i].Views > list[j].Views
+		}
+		return list[i].Name < list[j].Name
+	})
+	if top > 0 && len(list) > top {
+		list = list[:top]
+	}
+	return list
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/codeallergy/badgerstore"
+	"github.com/codeallergy/glue"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/sprintframework/pkg/core"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTrafficService(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-storage", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-storage", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	trafficService := service.TrafficService()
+
+	ctx, err := glue.New(log, configStore, core.ConfigRepository(1000), hostStore, trafficService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	verifyTraffic(t, trafficService, hostStore)
+
+}
+
+func verifyTraffic(t *testing.T, trafficService api.TrafficService, hostStore store.DataStore) {
+
+	ctx := context.Background()
+	now := time.Date(2026, 10, 19, 10, 15, 0, 0, time.UTC)
+
+	trafficService.RecordView(ctx, "about", "https://www.google.com/search?q=template", "10.0.0.1", "Mozilla/5.0 A", now)
+	trafficService.RecordView(ctx, "about", "", "10.0.0.1", "Mozilla/5.0 A", now.Add(time.Minute))
+	// own pages are not referrers, the first address is the client
+	trafficService.RecordView(ctx, "news", "https://localhost:8443/about", "10.0.0.1, 172.16.0.1", "Mozilla/5.0 A", now.Add(2*time.Minute))
+	trafficService.RecordView(ctx, "about", "https://news.ycombinator.com/", "10.0.0.2", "Mozilla/5.0 B", now.Add(time.Hour))
+	trafficService.RecordView(ctx, "about", "", "10.0.0.3", "Googlebot/2.1", now)
+	require.NoError(t, trafficService.Flush(ctx))
+
+	// visitor is counted once a day over flushes
+	trafficService.RecordView(ctx, "about", "", "10.0.0.1", "Mozilla/5.0 A", now.Add(2*time.Hour))
+	require.NoError(t, trafficService.Flush(ctx))
+
+	stats, err := trafficService.TrafficStats(ctx, now.Unix(), now.Add(2*time.Hour).Unix(), true, 1)
+	require.NoError(t, err)
+	require.Equal(t, now.Truncate(time.Hour).Unix(), stats.FromTime)
+	require.Equal(t, int64(5), stats.Views)
+	require.Equal(t, int64(2), stats.Visitors)
+	require.Equal(t, 3, len(stats.Points))
+	require.Equal(t, int64(3), stats.Points[0].Views)
+	require.Equal(t, int64(1), stats.Points[0].Visitors)
+	require.Equal(t, int64(1), stats.Points[1].Visitors)
+	require.Equal(t, int64(1), stats.Points[2].Views)
+	require.Equal(t, int64(0), stats.Points[2].Visitors)
+	require.Equal(t, 1, len(stats.TopPages))
+	require.Equal(t, "about", stats.TopPages[0].Name)
+	require.Equal(t, int64(4), stats.TopPages[0].Views)
+	require.Equal(t, int64(2), stats.TopPages[0].Visitors)
+	require.Equal(t, 1, len(stats.TopReferrers))
+	require.Equal(t, "google.com", stats.TopReferrers[0].Name)
+
+	// hashes rotate daily, so the next day the same visitor is new
+	trafficService.RecordView(ctx, "news", "", "10.0.0.1", "Mozilla/5.0 A", now.Add(24*time.Hour))
+	require.NoError(t, trafficService.Flush(ctx))
+
+	stats, err = trafficService.TrafficStats(ctx, now.Unix(), now.Add(24*time.Hour).Unix(), false, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(stats.Points))
+	require.Equal(t, int64(5), stats.Points[0].Views)
+	require.Equal(t, int64(2), stats.Points[0].Visitors)
+	require.Equal(t, int64(1), stats.Points[1].Visitors)
+	require.Equal(t, int64(3), stats.Visitors)
+	require.Equal(t, 2, len(stats.TopPages))
+	require.Equal(t, "news", stats.TopPages[1].Name)
+	require.Equal(t, int64(2), stats.TopPages[1].Views)
+	require.Equal(t, 2, len(stats.TopReferrers))
+
+	_, err = trafficService.TrafficStats(ctx, now.Add(-365*24*time.Hour).Unix(), now.Unix(), true, 10)
+	require.Error(t, err)
+
+	// raw IPs are never stored
+	err = hostStore.Enumerate(ctx).ByPrefix("traffic").Do(func(entry *store.RawEntry) bool {
+		require.False(t, bytes.Contains(entry.Key, []byte("10.0.0.")))
+		require.False(t, bytes.Contains(entry.Value, []byte("10.0.0.")))
+		return true
+	})
+	require.NoError(t, err)
+
+}
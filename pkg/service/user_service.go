@@ -17,6 +17,7 @@ import (
 	"github.com/codeallergy/sprintframework/pkg/util"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/proto"
 	"strings"
 	"time"
 )
@@ -260,12 +261,32 @@ func (t *implUserService) SaveUser(ctx context.Context, user *pb.UserEntity) (er
 		err = t.TransactionalManager.EndTransaction(ctx, err)
 	}()
 
-	err = t.HostStorage.Set(ctx).ByKey("%s:user", user.UserId).Proto(user)
+	stored := new(pb.UserEntity)
+	err = t.HostStorage.Get(ctx).ByKey("%s:user", user.UserId).ToProto(stored)
+	if err != nil {
+		return err
+	}
+
+	if stored.Version != user.Version {
+		return errors.Wrapf(ErrVersionConflict, "user '%s' was changed by another user, current version is %d", user.UserId, stored.Version)
+	}
+
+	// the caller keeps the version it was based on
+	saving := proto.Clone(user).(*pb.UserEntity)
+	saving.Version = stored.Version + 1
+	err = t.HostStorage.Set(ctx).ByKey("%s:user", user.UserId).Proto(saving)
 	if err != nil {
 		return err
 	}
 
 	// email index
+	if stored.Email != "" && stored.Email != user.Email {
+		err = t.HostStorage.Remove(ctx).ByKey("email:%s", stored.Email).Do()
+		if err != nil {
+			return err
+		}
+	}
+
 	err = t.HostStorage.Set(ctx).ByKey("email:%s", user.Email).String(user.UserId)
 	if err != nil {
 		return err
@@ -370,32 +391,14 @@ func (t *implUserService) DoWithUser(ctx context.Context, userId string, cb func
 	if err != nil {
 		return errors.Errorf("load user '%s', %v", userId, err)
 	}
-	savedEmail := user.Email
 
 	err = cb(user)
 	if err != nil {
 		return err
 	}
 
-	if savedEmail != user.Email {
-
-		err = t.HostStorage.Set(ctx).ByKey("email:%s", user.Email).String(userId)
-		if err != nil {
-			return err
-		}
-
-		err = t.HostStorage.Remove(ctx).ByKey("email:%s", savedEmail).Do()
-		if err != nil {
-			return err
-		}
-	}
-
-	err = t.HostStorage.Set(ctx).ByKey("%s:user", user.UserId).Proto(user)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// version is checked and incremented in the same transaction
+	return t.SaveUser(ctx, user)
 }
 
 func (t *implUserService) DumpUser(ctx context.Context, userId string, cb func(entry *store.RawEntry) bool) error {
@@ -449,3 +452,14 @@ func (t *implUserService) ValidateRecoverCode(ctx context.Context, email string,
 	return nil
 
 }
+
+func (t *implUserService) RevokeSessions(ctx context.Context, userId string) (epoch int64, err error) {
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+		user.SessionEpoch++
+		epoch = user.SessionEpoch
+		return nil
+	})
+
+	return
+}
@@ -92,14 +92,21 @@ func verifyUserCRUID(t *testing.T, userService api.UserService) {
 	require.Equal(t, "TT", user.LastName)
 	require.Equal(t, "test@test.com", user.Email)
 	require.NotNil(t, user.PasswordHash)
+	require.Equal(t, int64(1), user.Version)
 
 	user.LastName = "TTT"
 	err = userService.SaveUser(ctx, user)
 	require.NoError(t, err)
 
+	// the saved struct is based on the previous version now
+	user.LastName = "TTTT"
+	err = userService.SaveUser(ctx, user)
+	require.ErrorIs(t, err, service.ErrVersionConflict)
+
 	user, err = userService.AuthenticateUser(ctx, userId, "test")
 	require.NoError(t, err)
 	require.Equal(t, "TTT", user.LastName)
+	require.Equal(t, int64(2), user.Version)
 
 	var enumUser *pb.UserEntity
 	err = userService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
@@ -110,6 +117,14 @@ func verifyUserCRUID(t *testing.T, userService api.UserService) {
 	require.NotNil(t, enumUser)
 	require.Equal(t, userId, enumUser.UserId)
 
+	epoch, err := userService.RevokeSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), epoch)
+
+	user, err = userService.GetUser(ctx, userId)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), user.SessionEpoch)
+
 	err = userService.RemoveUser(ctx, userId)
 	require.NoError(t, err)
 
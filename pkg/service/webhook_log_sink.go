@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/codeallergy/store"
+	"github.com/codeallergy/template/pkg/api"
+	"github.com/codeallergy/template/pkg/pb"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const webhookSignatureHeader = "X-Signature-256"
+
+// posts security events to the webhook, events are queued in host-storage until delivered
+type implWebhookLogSink struct {
+	Log            *zap.Logger          `inject`
+	HostStorage    store.DataStore      `inject:"bean=host-storage"`
+
+	URL            string   `value:"security-log.webhook-sink.url,default="`
+	Secret         string   `value:"security-log.webhook-sink.secret,default="`
+	RetrySeconds   int      `value:"security-log.webhook-sink.retry-seconds,default=30"`
+	TimeoutSeconds int      `value:"security-log.webhook-sink.timeout-seconds,default=10"`
+	QueueTtl       int      `value:"security-log.webhook-sink.queue-ttl,default=604800"`  // one week ttl
+
+	client     *http.Client
+	wakeup     chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+func WebhookLogSink() api.SecurityLogSink {
+	return &implWebhookLogSink{}
+}
+
+func (t *implWebhookLogSink) BeanName() string {
+	return "webhook_log_sink"
+}
+
+func (t *implWebhookLogSink) PostConstruct() error {
+	if t.URL == "" {
+		return nil
+	}
+
+	t.client = &http.Client{Timeout: time.Duration(t.TimeoutSeconds) * time.Second}
+	t.wakeup = make(chan struct{}, 1)
+	t.done = make(chan struct{})
+
+	t.wg.Add(1)
+	go t.run()
+	return nil
+}
+
+func (t *implWebhookLogSink) Emit(userId string, event *pb.SecurityLogEntity) error {
+	if t.client == nil {
+		return nil
+	}
+
+	data, err := securityEventJSON(userId, event)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	seq, err := t.HostStorage.Increment(ctx).ByKey("security-log-webhook-seq").WithDelta(1).Do()
+	if err != nil {
+		return err
+	}
+
+	err = t.HostStorage.Set(ctx).ByKey("security-log-webhook:%020d", seq).WithTtl(t.QueueTtl).Binary(data)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case t.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (t *implWebhookLogSink) run() {
+	defer t.wg.Done()
+
+	for {
+		var retry <-chan time.Time
+		if err := t.deliverAll(); err != nil {
+			t.Log.Warn("WebhookDelivery", zap.String("url", t.URL), zap.Error(err))
+			retry = time.After(time.Duration(t.RetrySeconds) * time.Second)
+		}
+
+		select {
+		case <-t.done:
+			return
+		case <-t.wakeup:
+		case <-retry:
+		}
+	}
+}
+
+// delivers queued events in order and stops on the first failure
+func (t *implWebhookLogSink) deliverAll() error {
+	ctx := context.Background()
+
+	for {
+		var batch []*store.RawEntry
+		err := t.HostStorage.Enumerate(ctx).
+			ByPrefix("security-log-webhook:").
+			WithBatchSize(BatchSize).
+			Do(func(entry *store.RawEntry) bool {
+				batch = append(batch, &store.RawEntry{
+					Key:   append([]byte(nil), entry.Key...),
+					Value: entry.Value,
+				})
+				return len(batch) < BatchSize
+			})
+		if err != nil {
+			return err
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, entry := range batch {
+
+			select {
+			case <-t.done:
+				return nil
+			default:
+			}
+
+			if err := t.post(string(entry.Key), entry.Value); err != nil {
+				return err
+			}
+
+			if err := t.HostStorage.Remove(ctx).ByRawKey(entry.Key).Do(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (t *implWebhookLogSink) post(deliveryId string, data []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Delivery-Id", deliveryId)
+	if t.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256=" + webhookSignature(t.Secret, data))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func webhookSignature(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (t *implWebhookLogSink) Destroy() error {
+	t.closeOnce.Do(func() {
+		if t.done != nil {
+			close(t.done)
+			t.wg.Wait()
+		}
+	})
+	return nil
+}
+
+func (t *implWebhookLogSink) String() string {
+	return fmt.Sprintf("WebhookLogSink{%s}", t.URL)
+}
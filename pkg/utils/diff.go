@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package utils
+
+import "strings"
+
+const (
+	DiffEqual   = ' '
+	DiffRemoved = '-'
+	DiffAdded   = '+'
+
+	// limit of the LCS table, larger changes are shown as full replacement
+	maxDiffCells = 4000000
+)
+
+type DiffLine struct {
+	Op    byte
+	Text  string
+}
+
+func LineDiff(from, to string) []DiffLine {
+
+	a := splitLines(from)
+	b := splitLines(to)
+
+	var head, tail []DiffLine
+
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, DiffLine{Op: DiffEqual, Text: a[0]})
+		a, b = a[1:], b[1:]
+	}
+
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, DiffLine{Op: DiffEqual, Text: a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	out := head
+	if len(a) * len(b) > maxDiffCells {
+		for _, s := range a {
+			out = append(out, DiffLine{Op: DiffRemoved, Text: s})
+		}
+		for _, s := range b {
+			out = append(out, DiffLine{Op: DiffAdded, Text: s})
+		}
+	} else {
+		out = append(out, lcsDiff(a, b)...)
+	}
+
+	for i := len(tail) - 1; i >= 0; i-- {
+		out = append(out, tail[i])
+	}
+	return out
+}
+
+func lcsDiff(a, b []string) []DiffLine {
+
+	n, m := len(a), len(b)
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []DiffLine
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, DiffLine{Op: DiffRemoved, Text: a[i]})
+			i++
+		default:
+			out = append(out, DiffLine{Op: DiffAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		out = append(out, DiffLine{Op: DiffRemoved, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		out = append(out, DiffLine{Op: DiffAdded, Text: b[j]})
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2022-2023 Zander Schwid & Co. LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License
+ * is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express
+ * or implied. See the License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lower case language tag like 'de' or 'pt-br', underscores are replaced by dashes
+func NormalizeLocale(locale string) string {
+
+	var out strings.Builder
+	for _, ch := range strings.ToLower(strings.TrimSpace(locale)) {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+			out.WriteRune(ch)
+		case ch == '-' || ch == '_':
+			out.WriteByte('-')
+		}
+	}
+
+	return strings.Trim(out.String(), "-")
+}
+
+// returns the language of the locale, like 'pt' for 'pt-br', empty if there is no region
+func LocaleLanguage(locale string) string {
+	if i := strings.IndexByte(locale, '-'); i != -1 {
+		return locale[:i]
+	}
+	return ""
+}
+
+type acceptLanguage struct {
+	locale  string
+	quality float64
+}
+
+// returns normalized locales of the Accept-Language header by the quality, the wildcard is skipped
+func ParseAcceptLanguage(header string) []string {
+
+	var list []acceptLanguage
+	for _, part := range strings.Split(header, ",") {
+
+		fields := strings.Split(part, ";")
+		locale := NormalizeLocale(fields[0])
+		if locale == "" || strings.TrimSpace(fields[0]) == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			list = append(list, acceptLanguage{locale, quality})
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].quality > list[j].quality
+	})
+
+	var locales []string
+	for _, item := range list {
+		locales = append(locales, item.locale)
+	}
+	return locales
+}
@@ -61,8 +61,26 @@ func NormalizeCode(email string) string {
 	return strings.ReplaceAll(s, ":", "")
 }
 
+// nested pages are separated by '/', like docs/getting-started
 func NormalizePageId(pageId string) string {
-	return NormalizeIdentityField(pageId)
+
+	var segments []string
+	for _, segment := range strings.Split(pageId, "/") {
+		segment = NormalizeIdentityField(segment)
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// returns empty string for the top level page
+func ParentPageId(pageId string) string {
+	if i := strings.LastIndexByte(pageId, '/'); i != -1 {
+		return pageId[:i]
+	}
+	return ""
 }
 
 func NormalizeIdentityField(email string) string {